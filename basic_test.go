@@ -3,6 +3,7 @@ package upx
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -89,13 +90,14 @@ func TestPurge(t *testing.T) {
 	}
 	assert.Equal(t, err.Error(), "Purge failed urls:\nhttp://www.baidu.com\ntoo many fails\n")
 
-	fd, _ := os.Create("list")
+	listPath := filepath.Join(t.TempDir(), "list")
+	fd, _ := os.Create(listPath)
 	fd.WriteString(fmt.Sprintf("http://%s.b0.upaiyun.com/test.jpg\n", BUCKET_1))
 	fd.WriteString(fmt.Sprintf("http://%s.b0.upaiyun.com/测试.jpg\n", BUCKET_1))
 	fd.WriteString(fmt.Sprintf("http://%s.b0.upaiyun.com/%%E5%%8F%%88%%E6%%8B%%8D%%E4%%BA%%91.jpg\n", BUCKET_1))
 	fd.Close()
 
-	b, err = Upx("purge", "--list", "list")
+	b, err = Upx("purge", "--list", listPath)
 	assert.NoError(t, err)
 	assert.Equal(t, len(b), 0)
 }
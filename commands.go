@@ -8,9 +8,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/upyun/upx/crypt"
 	"github.com/upyun/upx/xerrors"
 	"github.com/urfave/cli"
 	"golang.org/x/term"
@@ -48,6 +51,275 @@ func CreateInitCheckFunc(login, check bool) cli.BeforeFunc {
 	}
 }
 
+// 限速配置的优先级：命令参数 > 全局参数 > 会话中保存的默认值
+func applyLimitRate(c *cli.Context) error {
+	value := session.LimitRate
+	if c.GlobalIsSet("limit-rate") {
+		value = c.GlobalString("limit-rate")
+	}
+	if c.IsSet("limit-rate") {
+		value = c.String("limit-rate")
+	}
+	if value == "" {
+		return nil
+	}
+	limiter, err := parseLimitRate(value)
+	if err != nil {
+		return fmt.Errorf("parse limit-rate: %v", err)
+	}
+	session.limiter = limiter
+	return nil
+}
+
+// ls/get/rm/tree 共用的过滤参数
+type matchFlags struct {
+	filters []*FilterRule
+	regexps []*regexp.Regexp
+}
+
+func (m *matchFlags) Flags() []cli.Flag {
+	return []cli.Flag{
+		cli.GenericFlag{Name: "include", Usage: "include files matching pattern, rules are checked in order like rsync", Value: &filterFlag{rules: &m.filters, kind: "include"}},
+		cli.GenericFlag{Name: "exclude", Usage: "exclude files matching pattern, rules are checked in order like rsync", Value: &filterFlag{rules: &m.filters, kind: "exclude"}},
+		cli.GenericFlag{Name: "filter-from", Usage: "read include(+)/exclude(-) rules from file", Value: &filterFlag{rules: &m.filters, kind: "filter-from"}},
+		cli.GenericFlag{Name: "regex", Usage: "relative path matches regular expression", Value: &regexFlag{regexps: &m.regexps}},
+		cli.GenericFlag{Name: "iregex", Usage: "like --regex, but the match is case insensitive", Value: &regexFlag{regexps: &m.regexps, ignoreCase: true}},
+		cli.StringFlag{Name: "mtime", Usage: "file's data was last modified n*24 hours ago, same as linux find command. also accepts units (30m, 2h, 7d) and ranges (2024-05-01..2024-05-31)"},
+		cli.StringFlag{Name: "newer-than", Usage: "file was modified after time, e.g. 2024-05-01T00:00 or 2h"},
+		cli.StringFlag{Name: "older-than", Usage: "file was modified before time, e.g. 2024-05-01 or 7d"},
+		cli.StringFlag{Name: "tz", Usage: "timezone of absolute times, e.g. UTC, Asia/Shanghai, +08:00", Value: "Local"},
+		cli.StringFlag{Name: "size", Usage: "file uses n units of space, +n for greater than and -n for less than, same as linux find command. units: K, M, G, T"},
+		cli.StringFlag{Name: "min-size", Usage: "file size is at least n, units: K, M, G, T"},
+		cli.StringFlag{Name: "max-size", Usage: "file size is at most n, units: K, M, G, T"},
+	}
+}
+
+// put、upload 和 sync 的忽略规则参数
+func ignoreFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{Name: "ignore", Usage: "ignore files matching gitignore pattern, can be repeated"},
+		cli.StringSliceFlag{Name: "ignore-file", Usage: "read gitignore patterns from file, can be repeated"},
+		cli.BoolFlag{Name: "show-ignored", Usage: "list ignored files and exit without uploading"},
+	}
+}
+
+var onConflictFlag = cli.StringFlag{
+	Name:  "on-conflict",
+	Usage: "action when target exists: overwrite, skip, newer, rename, backup or fail",
+}
+
+func applyOnConflict(c *cli.Context) error {
+	policy := c.String("on-conflict")
+	if policy != "" && !contains(conflictPolicies, policy) {
+		return fmt.Errorf("invalid --on-conflict %s, must be one of %s", policy, strings.Join(conflictPolicies, ", "))
+	}
+	session.onConflict = policy
+	return nil
+}
+
+var symlinksFlag = cli.StringFlag{
+	Name:  "symlinks",
+	Usage: "how to handle symlinks: follow, skip or preserve",
+}
+
+func applySymlinks(c *cli.Context) error {
+	policy := c.String("symlinks")
+	if policy != "" && !contains(symlinkPolicies, policy) {
+		return fmt.Errorf("invalid --symlinks %s, must be one of %s", policy, strings.Join(symlinkPolicies, ", "))
+	}
+	session.symlinks = policy
+	return nil
+}
+
+var (
+	preserveFlag      = cli.BoolFlag{Name: "preserve", Usage: "keep mtime and mode in metadata on upload and restore them on download"}
+	preserveOwnerFlag = cli.BoolFlag{Name: "preserve-owner", Usage: "like --preserve, also keep uid and gid"}
+)
+
+func applyPreserve(c *cli.Context) {
+	session.preserveOwner = c.Bool("preserve-owner")
+	session.preserve = c.Bool("preserve") || session.preserveOwner
+}
+
+var compressFlag = cli.StringFlag{
+	Name:  "compress",
+	Usage: "compress files on upload, get decompresses them automatically: gzip",
+}
+
+func applyCompress(c *cli.Context) error {
+	algo := c.String("compress")
+	if algo != "" && !contains(compressAlgorithms, algo) {
+		return fmt.Errorf("unsupported --compress %s, must be one of %s", algo, strings.Join(compressAlgorithms, ", "))
+	}
+	session.compress = algo
+	return nil
+}
+
+var (
+	encryptFlag = cli.BoolFlag{Name: "encrypt", Usage: "encrypt files with a random data key wrapped by the master key in --key-file"}
+	keyFileFlag = cli.StringFlag{Name: "key-file", Usage: "file of the 32 bytes master key, raw or hex/base64 encoded"}
+)
+
+func applyEncrypt(c *cli.Context) error {
+	if file := c.String("key-file"); file != "" {
+		key, err := crypt.LoadKeyFile(file)
+		if err != nil {
+			return err
+		}
+		session.encryptKey = key
+	}
+	session.encrypt = c.Bool("encrypt")
+	if session.encrypt && session.encryptKey == nil {
+		return fmt.Errorf("--encrypt requires --key-file")
+	}
+	return nil
+}
+
+// put、upload 和 get 的路径改写参数
+func rewriteFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{Name: "flatten", Usage: "drop directories, put all files directly under the target directory"},
+		cli.IntFlag{Name: "strip-components", Usage: "strip N leading directories from relative paths, files with fewer are skipped"},
+		cli.StringSliceFlag{Name: "rename", Usage: "rewrite relative paths with sed-like 's/from/to/[gi]', can be repeated"},
+		cli.BoolFlag{Name: "dry-run", Usage: "print source -> destination of each file without transferring"},
+	}
+}
+
+func applyRewriteFlags(c *cli.Context) error {
+	rewriter, err := NewPathRewriter(c.Int("strip-components"), c.StringSlice("rename"), c.Bool("flatten"))
+	if err != nil {
+		return err
+	}
+	session.rewriter = rewriter
+	session.dryRun = c.Bool("dry-run")
+	return nil
+}
+
+// 展开目标路径中的 {yyyy}、{hostname}、{env:NAME}、{git-sha} 等占位符
+func expandDestination(dest string) string {
+	expanded, err := expandPathTemplate(dest, time.Now())
+	if err != nil {
+		PrintErrorAndExit("expand %s: %v", dest, err)
+	}
+	return expanded
+}
+
+func applySkipExisting(c *cli.Context) error {
+	mode := c.String("skip-existing")
+	if mode != "" && !contains([]string{SkipBySize, SkipByMTime, SkipByMD5}, mode) {
+		return fmt.Errorf("invalid --skip-existing %s, must be size, mtime or md5", mode)
+	}
+	session.skipExisting = mode
+	return nil
+}
+
+// put、upload 和 sync 上传时附加的 HTTP 头
+func headerFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{Name: "header", Usage: "add HTTP header \"Key: Value\" to uploaded files, can be repeated"},
+		cli.StringSliceFlag{Name: "meta", Usage: "add metadata key=value as x-upyun-meta-key, can be repeated"},
+		cli.StringFlag{Name: "header-rules", Usage: "file of \"pattern Key: Value\" lines, set headers by remote path"},
+		cli.StringFlag{Name: "mime-map", Usage: "mime.types style file of \"type ext...\" lines, overrides built-in Content-Type detection"},
+	}
+}
+
+func applyHeaderFlags(c *cli.Context) error {
+	h := &UploadHeaders{headers: make(map[string]string)}
+	if file := c.String("header-rules"); file != "" {
+		rules, err := parseHeaderRules(file)
+		if err != nil {
+			return err
+		}
+		h.rules = rules
+	}
+	for _, value := range c.StringSlice("header") {
+		k, v, err := parseHeader(value)
+		if err != nil {
+			return err
+		}
+		h.headers[k] = v
+	}
+	for _, value := range c.StringSlice("meta") {
+		k, v, err := parseMeta(value)
+		if err != nil {
+			return err
+		}
+		h.headers[k] = v
+	}
+	if len(h.rules) > 0 || len(h.headers) > 0 {
+		session.headers = h
+	}
+	if file := c.String("mime-map"); file != "" {
+		types, err := parseMimeMap(file)
+		if err != nil {
+			return err
+		}
+		session.mimeTypes = types
+	}
+	return nil
+}
+
+func applyIgnoreFlags(c *cli.Context) error {
+	for _, file := range c.StringSlice("ignore-file") {
+		if _, err := os.Stat(file); err != nil {
+			return err
+		}
+	}
+	session.ignores = c.StringSlice("ignore")
+	session.ignoreFiles = c.StringSlice("ignore-file")
+	return nil
+}
+
+func (m *matchFlags) Apply(c *cli.Context, mc *MatchConfig) error {
+	mc.Filters = m.filters
+	mc.Regexps = m.regexps
+
+	loc, err := parseTimezone(c.String("tz"))
+	if err != nil {
+		return fmt.Errorf("parse tz: %v", err)
+	}
+	if c.String("mtime") != "" {
+		if err := parseMTime(c.String("mtime"), loc, mc); err != nil {
+			return fmt.Errorf("parse mtime: %v", err)
+		}
+	}
+	if c.String("newer-than") != "" {
+		t, err := parseTimeExpr(c.String("newer-than"), loc)
+		if err != nil {
+			return fmt.Errorf("parse newer-than: %v", err)
+		}
+		mc.SetAfter(t)
+	}
+	if c.String("older-than") != "" {
+		t, err := parseTimeExpr(c.String("older-than"), loc)
+		if err != nil {
+			return fmt.Errorf("parse older-than: %v", err)
+		}
+		mc.SetBefore(t)
+	}
+
+	if c.String("size") != "" {
+		if err := parseSizeFilter(c.String("size"), mc); err != nil {
+			return fmt.Errorf("parse size: %v", err)
+		}
+	}
+	if c.String("min-size") != "" {
+		size, err := parseSize(c.String("min-size"))
+		if err != nil {
+			return fmt.Errorf("parse min-size: %v", err)
+		}
+		mc.SetMinSize(size)
+	}
+	if c.String("max-size") != "" {
+		size, err := parseSize(c.String("max-size"))
+		if err != nil {
+			return fmt.Errorf("parse max-size: %v", err)
+		}
+		mc.SetMaxSize(size)
+	}
+	return nil
+}
+
 func NewLoginCommand() cli.Command {
 	return cli.Command{
 		Name:   "login",
@@ -74,6 +346,13 @@ func NewLoginCommand() cli.Command {
 				Print("")
 			}
 
+			if c.String("limit-rate") != "" {
+				if _, err := parseLimitRate(c.String("limit-rate")); err != nil {
+					PrintErrorAndExit("login failed: parse limit-rate: %v", err)
+				}
+				session.LimitRate = c.String("limit-rate")
+			}
+
 			if err := session.Init(); err != nil {
 				PrintErrorAndExit("login failed: %v", err)
 			}
@@ -91,6 +370,9 @@ func NewLoginCommand() cli.Command {
 
 			return nil
 		},
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "limit-rate", Usage: "default transfer rate limit of this session, e.g. 5M or 09:00-18:00=1M,5M"},
+		},
 	}
 }
 
@@ -228,6 +510,7 @@ func NewPwdCommand() cli.Command {
 }
 
 func NewLsCommand() cli.Command {
+	mf := &matchFlags{}
 	return cli.Command{
 		Name:      "ls",
 		Usage:     "List directory or file",
@@ -239,6 +522,9 @@ func NewLsCommand() cli.Command {
 				fpath = c.Args().First()
 			}
 			mc := &MatchConfig{}
+			if err := mf.Apply(c, mc); err != nil {
+				PrintErrorAndExit("ls %s: %v", fpath, err)
+			}
 			if c.Bool("d") {
 				mc.ItemType = DIR
 			}
@@ -248,47 +534,77 @@ func NewLsCommand() cli.Command {
 				mc.Wildcard = base
 				fpath = dir
 			}
-			if c.String("mtime") != "" {
-				err := parseMTime(c.String("mtime"), mc)
-				if err != nil {
-					PrintErrorAndExit("ls %s: parse mtime: %v", fpath, err)
-				}
+			mc.Start = c.String("start")
+			mc.End = c.String("end")
+			if mc.HasRange() && c.Bool("r") {
+				PrintErrorAndExit("ls %s: -r and --start/--end can't be used together", fpath)
 			}
 			session.color = c.Bool("color")
+			if c.IsSet("limit") || c.IsSet("cursor") {
+				if mc.HasRange() {
+					PrintErrorAndExit("ls %s: --limit/--cursor and --start/--end can't be used together", fpath)
+				}
+				session.LsPage(fpath, mc, c.Int("limit"), c.String("cursor"), c.Bool("r"))
+				return nil
+			}
 			session.Ls(fpath, mc, c.Int("c"), c.Bool("r"))
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{Name: "r", Usage: "reverse order"},
 			cli.BoolFlag{Name: "d", Usage: "only show directory"},
 			cli.BoolFlag{Name: "color", Usage: "colorful output"},
 			cli.IntFlag{Name: "c", Usage: "max items to list"},
-			cli.StringFlag{Name: "mtime", Usage: "file's data was last modified n*24 hours ago, same as linux find command."},
-		},
+			cli.IntFlag{Name: "limit", Usage: "max objects to read in one page, the next page cursor is printed to stderr"},
+			cli.StringFlag{Name: "cursor", Usage: "continue listing from the cursor printed by the previous page"},
+			cli.StringFlag{Name: "start", Usage: "only list paths lexicographically greater than or equal to start"},
+			cli.StringFlag{Name: "end", Usage: "only list paths lexicographically less than end"},
+		}, mf.Flags()...),
 	}
 }
 
 func NewGetCommand() cli.Command {
+	mf := &matchFlags{}
 	return cli.Command{
 		Name:      "get",
 		Usage:     "Get directory or file",
-		ArgsUsage: "[-c] <remote-path> [save-path]",
+		ArgsUsage: "[-c] <remote-path>... [save-path]",
 		Before:    CreateInitCheckFunc(LOGIN, CHECK),
 		Action: func(c *cli.Context) error {
 			upPath := c.Args().First()
 			localPath := "." + string(filepath.Separator)
 
-			if c.NArg() > 2 {
-				PrintErrorAndExit("upx get args limit 2")
-			}
-			if c.NArg() > 1 {
+			// 多个远程路径时最后一个参数为本地目录
+			var upPaths []string
+			if fromFile := c.String("from-file"); fromFile != "" {
+				if c.NArg() > 1 {
+					PrintErrorAndExit("get --from-file: too many arguments")
+				}
+				if c.NArg() > 0 {
+					localPath = c.Args().First()
+				}
+				paths, err := readPathList(fromFile, c.Bool("0"))
+				if err != nil {
+					PrintErrorAndExit("get --from-file %s: %v", fromFile, err)
+				}
+				if len(paths) == 0 {
+					PrintErrorAndExit("get --from-file %s: no remote path", fromFile)
+				}
+				upPaths = paths
+			} else if c.NArg() > 2 {
+				upPaths = c.Args()[:c.NArg()-1]
+				localPath = c.Args().Get(c.NArg() - 1)
+			} else if c.NArg() > 1 {
 				localPath = c.Args().Get(1)
 			}
 
 			mc := &MatchConfig{}
+			if err := mf.Apply(c, mc); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
 			base := path.Base(upPath)
 			dir := path.Dir(upPath)
-			if strings.Contains(base, "*") {
+			if upPaths == nil && strings.Contains(base, "*") {
 				mc.Wildcard, upPath = base, dir
 			}
 			if c.String("start") != "" {
@@ -297,16 +613,40 @@ func NewGetCommand() cli.Command {
 			if c.String("end") != "" {
 				mc.End = c.String("end")
 			}
-			if c.String("mtime") != "" {
-				err := parseMTime(c.String("mtime"), mc)
-				if err != nil {
-					PrintErrorAndExit("get %s: parse mtime: %v", upPath, err)
-				}
-			}
 			if c.Int("w") > 10 || c.Int("w") < 1 {
 				PrintErrorAndExit("max concurrent threads must between (1 - 10)")
 			}
-			if mc.Start != "" || mc.End != "" {
+			budget, err := parseSize(c.String("max-memory"))
+			if err != nil {
+				PrintErrorAndExit("get %s: parse max-memory: %v", upPath, err)
+			}
+			session.memoryBudget = budget
+			if err := applyOnConflict(c); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
+			if err := applySymlinks(c); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
+			session.unsafeLinks = c.Bool("unsafe-links")
+			applyPreserve(c)
+			session.decompress = c.BoolT("decompress")
+			if err := applyEncrypt(c); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
+			session.verify = c.BoolT("verify")
+			if err := applyLimitRate(c); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
+			if err := applyRewriteFlags(c); err != nil {
+				PrintErrorAndExit("get %s: %v", upPath, err)
+			}
+			localPath = expandDestination(localPath)
+			if upPaths != nil {
+				if c.Bool("in-progress") || mc.Start != "" || mc.End != "" {
+					PrintErrorAndExit("get: --in-progress and -start/-end can't be used with multiple remote paths")
+				}
+				session.GetMany(upPaths, localPath, mc, c.Int("w"), c.Bool("c"))
+			} else if mc.Start != "" || mc.End != "" {
 				if c.Bool("in-progress") {
 					PrintErrorAndExit("get %s: --in-progress and -start/-end can't be used together", upPath)
 				}
@@ -316,14 +656,25 @@ func NewGetCommand() cli.Command {
 			}
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.IntFlag{Name: "w", Usage: "max concurrent threads (1-10)", Value: 5},
 			cli.BoolFlag{Name: "c", Usage: "continue download, Resume Broken Download"},
 			cli.BoolFlag{Name: "in-progress", Usage: "download the file being uploaded"},
-			cli.StringFlag{Name: "mtime", Usage: "file's data was last modified n*24 hours ago, same as linux find command."},
 			cli.StringFlag{Name: "start", Usage: "file download range starting location"},
 			cli.StringFlag{Name: "end", Usage: "file download range ending location"},
-		},
+			cli.StringFlag{Name: "max-memory", Usage: "memory budget for download buffers, units: K, M, G", Value: "16M"},
+			cli.BoolTFlag{Name: "verify", Usage: "verify MD5 checksum, use --verify=false to disable"},
+			cli.StringFlag{Name: "limit-rate", Usage: "limit transfer rate, e.g. 5M or 09:00-18:00=1M,5M"},
+			cli.StringFlag{Name: "from-file", Usage: "read remote paths from file, one per line, - for stdin"},
+			cli.BoolFlag{Name: "0", Usage: "remote paths in --from-file are separated by NUL"},
+			onConflictFlag,
+			symlinksFlag,
+			cli.BoolFlag{Name: "unsafe-links", Usage: "restore preserved symlinks pointing outside the download directory"},
+			preserveFlag,
+			preserveOwnerFlag,
+			cli.BoolTFlag{Name: "decompress", Usage: "decompress files uploaded with --compress, use --decompress=false to disable"},
+			keyFileFlag,
+		}, append(rewriteFlags(), mf.Flags()...)...),
 	}
 }
 
@@ -331,7 +682,7 @@ func NewPutCommand() cli.Command {
 	return cli.Command{
 		Name:      "put",
 		Usage:     "Put directory or file",
-		ArgsUsage: "<local-path>|<url> [remote-path]",
+		ArgsUsage: "<local-path>|<url>|- [remote-path]",
 		Before:    CreateInitCheckFunc(LOGIN, CHECK),
 		Action: func(c *cli.Context) error {
 			localPath := c.Args().First()
@@ -345,6 +696,13 @@ func NewPutCommand() cli.Command {
 			if c.NArg() > 1 {
 				upPath = c.Args().Get(1)
 			}
+			if c.Bool("async-fetch") || c.String("fetch-list") != "" {
+				if c.Bool("encrypt") {
+					PrintErrorAndExit("put: --encrypt can't be used with --async-fetch or --fetch-list")
+				}
+				putAsyncFetch(c)
+				return nil
+			}
 			if c.Int("w") > 10 || c.Int("w") < 1 {
 				PrintErrorAndExit("max concurrent threads must between (1 - 10)")
 			}
@@ -357,24 +715,138 @@ func NewPutCommand() cli.Command {
 				defer f.Close()
 				log.SetOutput(f)
 			}
+			if err := applyIgnoreFlags(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if err := applyHeaderFlags(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if c.Bool("show-ignored") {
+				session.ShowIgnored([]string{localPath}, c.Bool("all"))
+				return nil
+			}
+			if err := applySkipExisting(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if err := applyOnConflict(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if err := applySymlinks(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			applyPreserve(c)
+			if err := applyCompress(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if err := applyEncrypt(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if session.encrypt && c.Bool("in-progress") {
+				PrintErrorAndExit("put %s: --encrypt can't be used with --in-progress", localPath)
+			}
+			session.verify = c.BoolT("verify")
+			if err := applyLimitRate(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			if c.Int("parallel") > 10 || c.Int("parallel") < 1 {
+				PrintErrorAndExit("max concurrent part uploads must between (1 - 10)")
+			}
+			session.uploadWorkers = c.Int("parallel")
+			if err := applyRewriteFlags(c); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			}
+			upPath = expandDestination(upPath)
+			var sizeHint int64
+			if c.String("size") != "" {
+				size, err := parseSize(c.String("size"))
+				if err != nil {
+					PrintErrorAndExit("put %s: parse size: %v", localPath, err)
+				}
+				sizeHint = size
+			}
 			session.Put(
 				localPath,
 				upPath,
 				c.Int("w"),
 				c.Bool("all"),
 				c.Bool("in-progress"),
+				sizeHint,
 			)
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.IntFlag{Name: "w", Usage: "max concurrent threads", Value: 5},
 			cli.BoolFlag{Name: "in-progress", Usage: "upload a file that can be downloaded simultaneously"},
 			cli.BoolFlag{Name: "all", Usage: "upload all files including hidden files"},
 			cli.StringFlag{Name: "err-log", Usage: "upload file error log to file"},
-		},
+			cli.IntFlag{Name: "parallel", Usage: "max concurrent part uploads of a single large file (1-10)", Value: 4},
+			cli.BoolTFlag{Name: "verify", Usage: "verify MD5 checksum, use --verify=false to disable"},
+			cli.StringFlag{Name: "limit-rate", Usage: "limit transfer rate, e.g. 5M or 09:00-18:00=1M,5M"},
+			cli.StringFlag{Name: "size", Usage: "expected size of stdin or url without Content-Length, units: K, M, G, T"},
+			cli.StringFlag{Name: "skip-existing", Usage: "skip files already uploaded, compared by size, mtime or md5"},
+			onConflictFlag,
+			symlinksFlag,
+			preserveFlag,
+			preserveOwnerFlag,
+			compressFlag,
+			encryptFlag,
+			keyFileFlag,
+			cli.BoolFlag{Name: "async-fetch", Usage: "let the server fetch the url instead of proxying through the client"},
+			cli.StringFlag{Name: "fetch-list", Usage: "file of urls to fetch asynchronously, one \"url [remote-path]\" per line"},
+			cli.BoolFlag{Name: "wait", Usage: "wait for the fetch tasks to finish and report results"},
+			cli.StringFlag{Name: "notify", Usage: "notify url of the fetch tasks"},
+			cli.StringFlag{Name: "notify-listen", Usage: "listen address to receive notifies when waiting, e.g. 0.0.0.0:8080"},
+			cli.DurationFlag{Name: "poll-interval", Usage: "interval of polling task progress when waiting", Value: 2 * time.Second},
+			cli.DurationFlag{Name: "wait-timeout", Usage: "max time to wait for the fetch tasks", Value: time.Hour},
+		}, append(append(ignoreFlags(), headerFlags()...), rewriteFlags()...)...),
 	}
 }
 
+// put --async-fetch <url> [remote-path] 或 put --fetch-list <file> [remote-dir]
+func putAsyncFetch(c *cli.Context) {
+	var tasks []*fetchTask
+	if list := c.String("fetch-list"); list != "" {
+		if c.NArg() > 1 {
+			PrintErrorAndExit("put --fetch-list: too many arguments")
+		}
+		upPath := "./"
+		if c.NArg() > 0 {
+			upPath = expandDestination(c.Args().First())
+		}
+		var err error
+		if tasks, err = parseFetchList(list, upPath); err != nil {
+			PrintErrorAndExit("put --fetch-list %s: %v", list, err)
+		}
+	} else {
+		if c.NArg() == 0 || c.NArg() > 2 {
+			PrintErrorAndExit("put --async-fetch <url> [remote-path]")
+		}
+		upPath, saveAs := "./", ""
+		if c.NArg() > 1 {
+			upPath = expandDestination(c.Args().Get(1))
+			// 以 / 结尾时保存到该目录下
+			if !strings.HasSuffix(upPath, "/") {
+				saveAs = upPath
+			}
+		}
+		task, err := newFetchTask(c.Args().First(), upPath, saveAs)
+		if err != nil {
+			PrintErrorAndExit("put --async-fetch: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		PrintErrorAndExit("put: no url to fetch")
+	}
+	session.AsyncFetch(tasks, &FetchConfig{
+		Notify:       c.String("notify"),
+		Listen:       c.String("notify-listen"),
+		Wait:         c.Bool("wait"),
+		PollInterval: c.Duration("poll-interval"),
+		Timeout:      c.Duration("wait-timeout"),
+	})
+}
+
 func NewUploadCommand() cli.Command {
 	return cli.Command{
 		Name:      "upload",
@@ -398,24 +870,73 @@ func NewUploadCommand() cli.Command {
 				defer f.Close()
 				log.SetOutput(f)
 			}
+			if err := applyIgnoreFlags(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if err := applyHeaderFlags(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if c.Bool("show-ignored") {
+				session.ShowIgnored(filenames, c.Bool("all"))
+				return nil
+			}
+			if err := applySkipExisting(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if err := applyOnConflict(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if err := applySymlinks(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			applyPreserve(c)
+			if err := applyCompress(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if err := applyEncrypt(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			session.verify = c.BoolT("verify")
+			if err := applyLimitRate(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
+			if c.Int("parallel") > 10 || c.Int("parallel") < 1 {
+				PrintErrorAndExit("max concurrent part uploads must between (1 - 10)")
+			}
+			session.uploadWorkers = c.Int("parallel")
+			if err := applyRewriteFlags(c); err != nil {
+				PrintErrorAndExit("upload: %v", err)
+			}
 			session.Upload(
 				filenames,
-				c.String("remote"),
+				expandDestination(c.String("remote")),
 				c.Int("w"),
 				c.Bool("all"),
 			)
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{Name: "all", Usage: "upload all files including hidden files"},
 			cli.IntFlag{Name: "w", Usage: "max concurrent threads", Value: 5},
 			cli.StringFlag{Name: "remote", Usage: "remote path", Value: "./"},
+			cli.StringFlag{Name: "skip-existing", Usage: "skip files already uploaded, compared by size, mtime or md5"},
+			onConflictFlag,
+			symlinksFlag,
+			preserveFlag,
+			preserveOwnerFlag,
+			compressFlag,
+			encryptFlag,
+			keyFileFlag,
 			cli.StringFlag{Name: "err-log", Usage: "upload file error log to file"},
-		},
+			cli.IntFlag{Name: "parallel", Usage: "max concurrent part uploads of a single large file (1-10)", Value: 4},
+			cli.BoolTFlag{Name: "verify", Usage: "verify MD5 checksum, use --verify=false to disable"},
+			cli.StringFlag{Name: "limit-rate", Usage: "limit transfer rate, e.g. 5M or 09:00-18:00=1M,5M"},
+		}, append(append(ignoreFlags(), headerFlags()...), rewriteFlags()...)...),
 	}
 }
 
 func NewRmCommand() cli.Command {
+	mf := &matchFlags{}
 	return cli.Command{
 		Name:      "rm",
 		Usage:     "Remove directory or file",
@@ -428,6 +949,9 @@ func NewRmCommand() cli.Command {
 			mc := &MatchConfig{
 				ItemType: FILE,
 			}
+			if err := mf.Apply(c, mc); err != nil {
+				PrintErrorAndExit("rm %s: %v", fpath, err)
+			}
 			if strings.Contains(base, "*") {
 				mc.Wildcard, fpath = base, dir
 			}
@@ -439,26 +963,24 @@ func NewRmCommand() cli.Command {
 				mc.ItemType = ITEM_NOT_SET
 			}
 
-			// if c.String("mtime") != "" {
-			// 	err := parseMTime(c.String("mtime"), mc)
-			// 	if err != nil {
-			// 		PrintErrorAndExit("rm %s: parse mtime: %v", fpath, err)
-			// 	}
-			// }
+			mc.Start = c.String("start")
+			mc.End = c.String("end")
 
 			session.Rm(fpath, mc, c.Bool("async"))
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{Name: "d", Usage: "only remove directories"},
 			cli.BoolFlag{Name: "a", Usage: "remove files, directories and their contents recursively, never prompt"},
 			cli.BoolFlag{Name: "async", Usage: "remove asynchronously"},
-			// cli.StringFlag{Name: "mtime", Usage: "file's data was last modified n*24 hours ago, same as linux find command."},
-		},
+			cli.StringFlag{Name: "start", Usage: "only remove paths lexicographically greater than or equal to start"},
+			cli.StringFlag{Name: "end", Usage: "only remove paths lexicographically less than end"},
+		}, mf.Flags()...),
 	}
 }
 
 func NewTreeCommand() cli.Command {
+	mf := &matchFlags{}
 	return cli.Command{
 		Name:      "tree",
 		Usage:     "List contents of directories in a tree-like format",
@@ -469,13 +991,17 @@ func NewTreeCommand() cli.Command {
 			if c.NArg() > 0 {
 				fpath = c.Args().First()
 			}
+			mc := &MatchConfig{}
+			if err := mf.Apply(c, mc); err != nil {
+				PrintErrorAndExit("tree %s: %v", fpath, err)
+			}
 			session.color = c.Bool("color")
-			session.Tree(fpath)
+			session.Tree(fpath, mc)
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{Name: "color", Usage: "colorful output"},
-		},
+		}, mf.Flags()...),
 	}
 }
 
@@ -494,14 +1020,51 @@ func NewSyncCommand() cli.Command {
 			if c.Int("w") > 10 || c.Int("w") < 1 {
 				PrintErrorAndExit("max concurrent threads must between (1 - 10)")
 			}
+			if err := applyIgnoreFlags(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			if err := applyHeaderFlags(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			if c.Bool("show-ignored") {
+				session.ShowIgnored([]string{localPath}, true)
+				return nil
+			}
+			if err := applySymlinks(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			applyPreserve(c)
+			if err := applyCompress(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			if err := applyEncrypt(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			session.verify = c.BoolT("verify")
+			if err := applyLimitRate(c); err != nil {
+				PrintErrorAndExit("sync %s: %v", localPath, err)
+			}
+			if c.Int("parallel") > 10 || c.Int("parallel") < 1 {
+				PrintErrorAndExit("max concurrent part uploads must between (1 - 10)")
+			}
+			session.uploadWorkers = c.Int("parallel")
 			session.Sync(localPath, upPath, c.Int("w"), c.Bool("delete"), c.Bool("strong"))
 			return nil
 		},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.IntFlag{Name: "w", Usage: "max concurrent threads", Value: 5},
 			cli.BoolFlag{Name: "delete", Usage: "delete extraneous files from last sync"},
 			cli.BoolFlag{Name: "strong", Usage: "strong consistency"},
-		},
+			cli.IntFlag{Name: "parallel", Usage: "max concurrent part uploads of a single large file (1-10)", Value: 4},
+			cli.BoolTFlag{Name: "verify", Usage: "verify MD5 checksum, use --verify=false to disable"},
+			cli.StringFlag{Name: "limit-rate", Usage: "limit transfer rate, e.g. 5M or 09:00-18:00=1M,5M"},
+			symlinksFlag,
+			preserveFlag,
+			preserveOwnerFlag,
+			compressFlag,
+			encryptFlag,
+			keyFileFlag,
+		}, append(ignoreFlags(), headerFlags()...)...),
 	}
 }
 
@@ -603,13 +1166,23 @@ func NewCopyCommand() cli.Command {
 			if c.NArg() != 2 {
 				PrintErrorAndExit("invalid command args")
 			}
-			if err := session.Copy(c.Args()[0], c.Args()[1], c.Bool("f")); err != nil {
+			mc := &MatchConfig{
+				Start: c.String("start"),
+				End:   c.String("end"),
+			}
+			if err := applyOnConflict(c); err != nil {
+				PrintErrorAndExit(err.Error())
+			}
+			if err := session.Copy(c.Args()[0], c.Args()[1], mc, c.Bool("f")); err != nil {
 				PrintErrorAndExit(err.Error())
 			}
 			return nil
 		},
 		Flags: []cli.Flag{
 			cli.BoolFlag{Name: "f", Usage: "Force overwrite existing files"},
+			onConflictFlag,
+			cli.StringFlag{Name: "start", Usage: "only handle paths lexicographically greater than or equal to start, source must be a directory"},
+			cli.StringFlag{Name: "end", Usage: "only handle paths lexicographically less than end, source must be a directory"},
 		},
 	}
 }
@@ -624,13 +1197,123 @@ func NewMoveCommand() cli.Command {
 			if c.NArg() != 2 {
 				PrintErrorAndExit("invalid command args")
 			}
-			if err := session.Move(c.Args()[0], c.Args()[1], c.Bool("f")); err != nil {
+			mc := &MatchConfig{
+				Start: c.String("start"),
+				End:   c.String("end"),
+			}
+			if err := applyOnConflict(c); err != nil {
+				PrintErrorAndExit(err.Error())
+			}
+			if err := session.Move(c.Args()[0], c.Args()[1], mc, c.Bool("f")); err != nil {
 				PrintErrorAndExit(err.Error())
 			}
 			return nil
 		},
 		Flags: []cli.Flag{
 			cli.BoolFlag{Name: "f", Usage: "Force overwrite existing files"},
+			onConflictFlag,
+			cli.StringFlag{Name: "start", Usage: "only handle paths lexicographically greater than or equal to start, source must be a directory"},
+			cli.StringFlag{Name: "end", Usage: "only handle paths lexicographically less than end, source must be a directory"},
+		},
+	}
+}
+
+func NewUploadsCommand() cli.Command {
+	return cli.Command{
+		Name:  "uploads",
+		Usage: "manage unfinished multipart uploads",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list unfinished multipart uploads, including server uploads without a local record",
+				Before: CreateInitCheckFunc(NO_LOGIN, NO_CHECK),
+				Action: func(c *cli.Context) error {
+					records, err := listUploadRecords()
+					if err != nil {
+						PrintErrorAndExit("uploads list: %v", err)
+					}
+					local := make(map[string]bool)
+					for _, r := range records {
+						local[r.UploadID] = true
+						progress := 100.0
+						if r.Size > 0 {
+							progress = float64(r.Uploaded()) * 100 / float64(r.Size)
+						}
+						Print("%s %5.1f%% %s %s => %s:%s",
+							r.id[:12],
+							progress,
+							time.Unix(r.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+							r.LocalPath,
+							r.Bucket(),
+							r.Path(),
+						)
+					}
+
+					// 服务端未完成的上传中，本地没有记录的无法续传，只会在服务端过期
+					if c.Bool("local") || session == nil {
+						return nil
+					}
+					if err := session.Init(); err != nil {
+						PrintErrorAndExit("uploads list: %v", err)
+					}
+					files, err := session.listServerUploads()
+					if err != nil {
+						PrintErrorAndExit("uploads list: %v", err)
+					}
+					for _, f := range files {
+						if local[f.UUID] {
+							continue
+						}
+						Print("%-12.12s %6s %s %s => %s:%s",
+							f.UUID,
+							"-",
+							time.Unix(f.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+							"(no local record)",
+							session.Bucket,
+							path.Join("/", f.Key),
+						)
+					}
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "local", Usage: "only list local records, don't query the server"},
+				},
+			},
+			{
+				Name:      "forget",
+				Usage:     "delete the local records of unfinished multipart uploads, the uploaded parts stay on the server until they expire",
+				ArgsUsage: "<upload-id>...",
+				Before:    CreateInitCheckFunc(NO_LOGIN, NO_CHECK),
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 && !c.Bool("all") {
+						PrintErrorAndExit("uploads forget: missing upload id")
+					}
+					records, err := listUploadRecords()
+					if err != nil {
+						PrintErrorAndExit("uploads forget: %v", err)
+					}
+					for _, r := range records {
+						matched := c.Bool("all")
+						for _, id := range c.Args() {
+							if strings.HasPrefix(r.id, id) {
+								matched = true
+							}
+						}
+						if !matched {
+							continue
+						}
+						if err := r.Remove(); err != nil {
+							PrintError("uploads forget %s: %v", r.id[:12], err)
+							continue
+						}
+						Print("forgot %s %s => %s:%s", r.id[:12], r.LocalPath, r.Bucket(), r.Path())
+					}
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "all", Usage: "forget all unfinished uploads"},
+				},
+			},
 		},
 	}
 }
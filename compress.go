@@ -0,0 +1,153 @@
+package upx
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+// --compress 上传时压缩，压缩方式和原始大小保存在元信息中，get 时自动解压
+const (
+	CompressGzip = "gzip"
+
+	CompressMetaHeader     = MetaHeaderPrefix + "Compress"
+	OriginalSizeMetaHeader = MetaHeaderPrefix + "Original-Size"
+
+	// 小于该大小的文件不压缩
+	minCompressSize = 1024
+)
+
+var compressAlgorithms = []string{CompressGzip}
+
+// 本身已经压缩过的格式，再次压缩基本没有收益
+var incompressibleExts = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".jar": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".flac": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true, ".flv": true,
+	".woff": true, ".woff2": true,
+}
+
+// 根据扩展名和文件开头的内容判断是否值得压缩
+func compressible(name string, size int64, head []byte) bool {
+	if size < minCompressSize || incompressibleExts[strings.ToLower(filepath.Ext(name))] {
+		return false
+	}
+	t := http.DetectContentType(head)
+	for _, prefix := range []string{"image/", "video/", "audio/", "font/", "application/zip", "application/x-gzip", "application/x-rar-compressed"} {
+		if strings.HasPrefix(t, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// 是否需要压缩上传 localPath
+func (sess *Session) shouldCompress(localPath string, localInfo os.FileInfo) bool {
+	if sess.compress == "" {
+		return false
+	}
+	fd, err := os.Open(localPath)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	head := make([]byte, sniffLen)
+	n, _ := fd.Read(head)
+	return compressible(localPath, localInfo.Size(), head[:n])
+}
+
+// 压缩后的大小事先不知道，边压缩边按数据流上传
+func (sess *Session) putCompressedFile(localPath, upPath string, localInfo os.FileInfo) error {
+	fd, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, fd)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	meta := map[string]string{
+		CompressMetaHeader:     sess.compress,
+		OriginalSizeMetaHeader: fmt.Sprint(localInfo.Size()),
+	}
+	for k, v := range sess.preserveHeaders(localInfo) {
+		meta[k] = v
+	}
+	// 原始大小作为预估的大小，用于选择分片大小
+	err = sess.putStream(pr, upPath, localInfo.Size(), meta)
+	pr.CloseWithError(err)
+	return err
+}
+
+// 上传时记录的原始大小，没有压缩时为云端文件的大小
+func originalSize(upInfo *upyun.FileInfo) int64 {
+	if v, ok := metaValue(upInfo.Meta, OriginalSizeMetaHeader); ok {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return size
+		}
+	}
+	return upInfo.Size
+}
+
+// 下载完成后解压压缩上传的文件
+func (sess *Session) decompressFile(localPath string, upInfo *upyun.FileInfo) error {
+	algo, ok := metaValue(upInfo.Meta, CompressMetaHeader)
+	if !sess.decompress || !ok {
+		return nil
+	}
+	if algo != CompressGzip {
+		return fmt.Errorf("decompress %s: unsupported compression %s", localPath, algo)
+	}
+	return rewriteFile(localPath, func(w io.Writer, r io.Reader) error {
+		zr, err := gzip.NewReader(r)
+		if err == nil {
+			_, err = io.Copy(w, zr)
+		}
+		if err != nil {
+			return fmt.Errorf("decompress %s: %v", localPath, err)
+		}
+		return nil
+	})
+}
+
+// 将 localPath 的内容经过 fn 处理后替换原文件，处理的结果先写入临时文件
+func rewriteFile(localPath string, fn func(w io.Writer, r io.Reader) error) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmpPath := localPath + TempFileSuffix
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err = fn(dst, src); err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	src.Close()
+	return os.Rename(tmpPath, localPath)
+}
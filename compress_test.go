@@ -0,0 +1,58 @@
+package upx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+func TestCompressible(t *testing.T) {
+	text := []byte(strings.Repeat("2024-05-01 INFO request ok\n", 100))
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+
+	assert.True(t, compressible("app.log", 4096, text))
+	assert.True(t, compressible("export", 4096, text))
+	assert.False(t, compressible("app.log", 100, text))
+	assert.False(t, compressible("archive.tar.GZ", 4096, text))
+	assert.False(t, compressible("photo", 4096, png))
+}
+
+func TestDecompressFile(t *testing.T) {
+	content := strings.Repeat("hello upx\n", 1000)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(content))
+	zw.Close()
+
+	localPath := filepath.Join(t.TempDir(), "data.json")
+	assert.NoError(t, os.WriteFile(localPath, buf.Bytes(), 0644))
+
+	upInfo := &upyun.FileInfo{
+		Size: int64(buf.Len()),
+		Meta: map[string]string{
+			strings.ToLower(CompressMetaHeader):     CompressGzip,
+			strings.ToLower(OriginalSizeMetaHeader): "10000",
+		},
+	}
+	assert.Equal(t, int64(10000), originalSize(upInfo))
+
+	// 关闭解压时保持原样
+	sess := &Session{}
+	assert.NoError(t, sess.decompressFile(localPath, upInfo))
+	b, _ := os.ReadFile(localPath)
+	assert.Equal(t, buf.Bytes(), b)
+
+	sess.decompress = true
+	assert.NoError(t, sess.decompressFile(localPath, upInfo))
+	b, _ = os.ReadFile(localPath)
+	assert.Equal(t, content, string(b))
+
+	upInfo.Meta[strings.ToLower(CompressMetaHeader)] = "zstd"
+	assert.Error(t, sess.decompressFile(localPath, upInfo))
+}
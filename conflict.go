@@ -0,0 +1,119 @@
+package upx
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+// --on-conflict 目标文件已存在时的处理方式，未指定时保持各命令原来的行为
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictNewer     = "newer"
+	ConflictRename    = "rename"
+	ConflictBackup    = "backup"
+	ConflictFail      = "fail"
+
+	// backup 时保留的旧文件后缀
+	BackupSuffix = "~"
+
+	// rename 时最多尝试的编号
+	maxRenameTries = 1000
+)
+
+var conflictPolicies = []string{ConflictOverwrite, ConflictSkip, ConflictNewer, ConflictRename, ConflictBackup, ConflictFail}
+
+// 目标文件所在的文件系统，本地或云端
+type conflictFS struct {
+	exists func(fpath string) bool
+	rename func(src, dst string) error
+}
+
+var localConflictFS = &conflictFS{
+	exists: func(fpath string) bool {
+		_, err := os.Lstat(fpath)
+		return err == nil
+	},
+	rename: os.Rename,
+}
+
+func (sess *Session) remoteConflictFS() *conflictFS {
+	return &conflictFS{
+		exists: func(fpath string) bool {
+			_, err := sess.updriver.GetInfo(fpath)
+			return err == nil
+		},
+		rename: func(src, dst string) error {
+			// 覆盖上一次的备份
+			sess.updriver.Delete(&upyun.DeleteObjectConfig{Path: dst})
+			return sess.updriver.Move(&upyun.MoveObjectConfig{SrcPath: src, DestPath: dst})
+		},
+	}
+}
+
+// 在文件名和扩展名之间加上编号，a.txt => a.1.txt
+func numberedPath(fpath string, n int) string {
+	base := fpath[strings.LastIndexAny(fpath, `/\`)+1:]
+	ext := path.Ext(base)
+	if ext == base {
+		ext = ""
+	}
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(fpath, ext), n, ext)
+}
+
+// 记录 rename 选中的路径，已被其他任务选中时返回 false
+func (sess *Session) reserveRenamed(fpath string) bool {
+	sess.rmu.Lock()
+	defer sess.rmu.Unlock()
+	if sess.renamed == nil {
+		sess.renamed = make(map[string]bool)
+	}
+	if sess.renamed[fpath] {
+		return false
+	}
+	sess.renamed[fpath] = true
+	return true
+}
+
+// 目标文件 dest 已存在时按 sess.onConflict 处理，srcTime 和 destTime 为两边的修改时间
+// 返回实际写入的路径，ok 为 false 时跳过该文件，每次的决定都会输出
+func (sess *Session) resolveConflict(fs *conflictFS, dest string, srcTime, destTime time.Time) (string, bool, error) {
+	switch sess.onConflict {
+	case ConflictOverwrite:
+		Print("conflict %s: overwrite", dest)
+		return dest, true, nil
+	case ConflictSkip:
+		Print("conflict %s: skip", dest)
+		return dest, false, nil
+	case ConflictNewer:
+		if srcTime.After(destTime) {
+			Print("conflict %s: source is newer, overwrite", dest)
+			return dest, true, nil
+		}
+		Print("conflict %s: target is not older, skip", dest)
+		return dest, false, nil
+	case ConflictRename:
+		for n := 1; n <= maxRenameTries; n++ {
+			if p := numberedPath(dest, n); !fs.exists(p) && sess.reserveRenamed(p) {
+				Print("conflict %s: rename to %s", dest, p)
+				return p, true, nil
+			}
+		}
+		return dest, false, fmt.Errorf("%s: too many renamed copies", dest)
+	case ConflictBackup:
+		backup := dest + BackupSuffix
+		if err := fs.rename(dest, backup); err != nil {
+			return dest, false, fmt.Errorf("backup %s: %v", dest, err)
+		}
+		Print("conflict %s: backup to %s", dest, backup)
+		return dest, true, nil
+	case ConflictFail:
+		return dest, false, fmt.Errorf("%s already exists", dest)
+	}
+	return dest, true, nil
+}
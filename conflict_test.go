@@ -0,0 +1,48 @@
+package upx
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberedPath(t *testing.T) {
+	assert.Equal(t, "/a/b.1.txt", numberedPath("/a/b.txt", 1))
+	assert.Equal(t, "/a/b.2", numberedPath("/a/b", 2))
+	assert.Equal(t, "/a.d/.bashrc.3", numberedPath("/a.d/.bashrc", 3))
+}
+
+func TestResolveConflictRenameConcurrent(t *testing.T) {
+	sess := &Session{onConflict: ConflictRename}
+	// 只有原文件存在，并发的任务各自检查时都看不到其他任务将要写入的文件
+	fs := &conflictFS{
+		exists: func(fpath string) bool { return fpath == "/a.txt" },
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		dests = make(map[string]bool)
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dest, ok, err := sess.resolveConflict(fs, "/a.txt", time.Now(), time.Now())
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			mu.Lock()
+			dests[dest] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, dests, 10)
+	for i := 1; i <= 10; i++ {
+		assert.True(t, dests[fmt.Sprintf("/a.%d.txt", i)])
+	}
+}
@@ -104,3 +104,41 @@ func TestCopy(t *testing.T) {
 		[]string{"FILE1", "FILE2", "FILE3"},
 	)
 }
+
+func TestCopyOnConflict(t *testing.T) {
+	SetUp()
+	defer TearDown()
+
+	upRootPath := path.Join(ROOT, "copy-conflict")
+	Upx("mkdir", upRootPath)
+
+	localRootPath, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	localRootName := filepath.Base(localRootPath)
+	base := path.Join(upRootPath, localRootName)
+
+	CreateFile(path.Join(localRootPath, "FILE1"))
+	CreateFile(path.Join(localRootPath, "FILE2"))
+	_, err = Upx("put", localRootPath, upRootPath)
+	assert.NoError(t, err)
+
+	// 目标已存在时 fail 返回错误，skip 不做任何操作
+	_, err = Upx("cp", "--on-conflict", "fail", path.Join(base, "FILE1"), path.Join(base, "FILE2"))
+	assert.Error(t, err)
+	_, err = Upx("cp", "--on-conflict", "skip", path.Join(base, "FILE1"), path.Join(base, "FILE2"))
+	assert.NoError(t, err)
+
+	// rename 添加编号，backup 保留旧文件
+	_, err = Upx("cp", "--on-conflict", "rename", path.Join(base, "FILE1"), path.Join(base, "FILE2"))
+	assert.NoError(t, err)
+	_, err = Upx("cp", "--on-conflict", "backup", path.Join(base, "FILE1"), path.Join(base, "FILE2"))
+	assert.NoError(t, err)
+
+	files, err := Ls(base)
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t,
+		files,
+		[]string{"FILE1", "FILE2", "FILE2.1", "FILE2~"},
+	)
+}
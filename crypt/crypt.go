@@ -0,0 +1,281 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// 主密钥和数据密钥的长度，使用 AES-256
+	KeySize = 32
+
+	// 每个加密块附加的认证标签长度
+	TagSize = 16
+
+	// 明文按该大小分块加密，最后一块可以更小
+	DefaultChunkSize = 64 * 1024
+)
+
+var (
+	// 主密钥不能解开数据密钥
+	ErrWrongKey = errors.New("wrong key")
+
+	// 密文被篡改或者被截断
+	ErrAuth = errors.New("message authentication failed")
+)
+
+// 读取主密钥文件，内容为 32 字节的原始密钥，或者 hex、base64 编码的密钥
+func LoadKeyFile(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == KeySize {
+		return b, nil
+	}
+	text := string(bytes.TrimSpace(b))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: key must be %d bytes, raw or hex/base64 encoded", filename, KeySize)
+}
+
+// 主密钥的标识，用于下载时确认使用了正确的密钥，不会泄露密钥本身
+func KeyID(master []byte) string {
+	sum := sha256.Sum256(append([]byte("upx key id\n"), master...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 随机生成一个数据密钥
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 使用主密钥加密数据密钥，结果为 nonce || 密文 || 标签
+func WrapKey(master, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte("upx data key")), nil
+}
+
+func UnwrapKey(master, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte("upx data key"))
+	if err != nil || len(key) != KeySize {
+		return nil, ErrWrongKey
+	}
+	return key, nil
+}
+
+// 明文大小为 size 时密文的大小，空文件也会有一个只包含标签的块
+func EncryptedSize(size int64, chunkSize int) int64 {
+	return size + chunks(size, chunkSize)*TagSize
+}
+
+func chunks(size int64, chunkSize int) int64 {
+	n := (size + int64(chunkSize) - 1) / int64(chunkSize)
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// 每一块的 nonce 为块的序号，附加数据标记是否是最后一块，防止密文被截断或者调换顺序
+func chunkNonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func chunkAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// 明文文件加密后的内容，可以随机读取任意位置，用于分片上传和断点续传
+// 同一个数据密钥和明文得到的密文总是相同的
+type Encrypter struct {
+	aead      cipher.AEAD
+	src       io.ReaderAt
+	size      int64
+	chunkSize int
+}
+
+func NewEncrypter(dataKey []byte, src io.ReaderAt, size int64, chunkSize int) (*Encrypter, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypter{aead: aead, src: src, size: size, chunkSize: chunkSize}, nil
+}
+
+// 密文的大小
+func (e *Encrypter) Size() int64 {
+	return EncryptedSize(e.size, e.chunkSize)
+}
+
+func (e *Encrypter) ReadAt(p []byte, off int64) (int, error) {
+	total := e.Size()
+	sealedSize := int64(e.chunkSize + TagSize)
+	n := 0
+	plain := make([]byte, e.chunkSize)
+	for len(p) > 0 && off < total {
+		index := off / sealedSize
+		start := index * int64(e.chunkSize)
+		length := min(int64(e.chunkSize), e.size-start)
+		if _, err := e.src.ReadAt(plain[:length], start); err != nil && err != io.EOF {
+			return n, err
+		}
+		last := index == chunks(e.size, e.chunkSize)-1
+		sealed := e.aead.Seal(nil, chunkNonce(index), plain[:length], chunkAD(last))
+		c := copy(p, sealed[off-index*sealedSize:])
+		p = p[c:]
+		off += int64(c)
+		n += c
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// 加密数据流，用于大小事先未知的数据，结果与 Encrypter 相同
+type EncryptReader struct {
+	aead      cipher.AEAD
+	r         io.Reader
+	chunkSize int
+	index     int64
+	// 预读的下一块明文，用于判断当前块是否是最后一块
+	next []byte
+	buf  []byte
+	done bool
+	err  error
+}
+
+func NewEncryptReader(dataKey []byte, r io.Reader, chunkSize int) (*EncryptReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptReader{aead: aead, r: r, chunkSize: chunkSize}, nil
+}
+
+func (e *EncryptReader) readChunk() ([]byte, error) {
+	chunk := make([]byte, e.chunkSize)
+	n, err := io.ReadFull(e.r, chunk)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return chunk[:n], err
+}
+
+func (e *EncryptReader) Read(p []byte) (int, error) {
+	for len(e.buf) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		if e.done {
+			return 0, io.EOF
+		}
+		cur := e.next
+		if cur == nil {
+			var err error
+			if cur, err = e.readChunk(); err != nil && err != io.EOF {
+				e.err = err
+				continue
+			}
+		}
+		next, err := e.readChunk()
+		if err != nil && err != io.EOF {
+			e.err = err
+			continue
+		}
+		last := len(next) == 0
+		e.buf = e.aead.Seal(nil, chunkNonce(e.index), cur, chunkAD(last))
+		e.index++
+		e.next = next
+		e.done = last
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, nil
+}
+
+// 解密数据流写入 w，密文被篡改或者被截断时返回 ErrAuth
+func Decrypt(dataKey []byte, w io.Writer, r io.Reader, chunkSize int) error {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	sealedSize := chunkSize + TagSize
+	read := func() ([]byte, error) {
+		buf := make([]byte, sealedSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return buf[:n], err
+	}
+
+	cur, err := read()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for index := int64(0); ; index++ {
+		next, err := read()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := len(next) == 0
+		plain, err := aead.Open(nil, chunkNonce(index), cur, chunkAD(last))
+		if err != nil {
+			return ErrAuth
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		cur = next
+	}
+}
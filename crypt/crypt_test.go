@@ -0,0 +1,116 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const chunkSize = 1024
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		enc, err := NewEncrypter(key, bytes.NewReader(plain), int64(size), chunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := io.ReadAll(io.NewSectionReader(enc, 0, enc.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(sealed)) != EncryptedSize(int64(size), chunkSize) {
+			t.Fatalf("size %d: got %d bytes, want %d", size, len(sealed), EncryptedSize(int64(size), chunkSize))
+		}
+
+		// 随机位置读取的结果与顺序读取一致
+		if len(sealed) > 100 {
+			part := make([]byte, 100)
+			off := int64(len(sealed) / 3)
+			if _, err := enc.ReadAt(part, off); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(part, sealed[off:off+100]) {
+				t.Fatalf("size %d: ReadAt mismatch", size)
+			}
+		}
+
+		// 数据流加密的结果相同
+		er, _ := NewEncryptReader(key, bytes.NewReader(plain), chunkSize)
+		streamed, err := io.ReadAll(er)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed, sealed) {
+			t.Fatalf("size %d: stream encryption mismatch", size)
+		}
+
+		var out bytes.Buffer
+		if err := Decrypt(key, &out, bytes.NewReader(sealed), chunkSize); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), plain) {
+			t.Fatalf("size %d: decrypted data mismatch", size)
+		}
+
+		// 截断最后一块
+		if size > chunkSize {
+			truncated := sealed[:len(sealed)-len(sealed)%(chunkSize+TagSize)]
+			if err := Decrypt(key, io.Discard, bytes.NewReader(truncated), chunkSize); err != ErrAuth {
+				t.Fatalf("size %d: truncated: got %v, want ErrAuth", size, err)
+			}
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	master, _ := NewDataKey()
+	other, _ := NewDataKey()
+	dataKey, _ := NewDataKey()
+
+	wrapped, err := WrapKey(master, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnwrapKey(master, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap: %v", err)
+	}
+	if _, err := UnwrapKey(other, wrapped); err != ErrWrongKey {
+		t.Fatalf("unwrap with other key: got %v, want ErrWrongKey", err)
+	}
+	if KeyID(master) == KeyID(other) {
+		t.Fatal("key ids should differ")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := NewDataKey()
+	write := func(name string, b []byte) string {
+		fpath := filepath.Join(dir, name)
+		os.WriteFile(fpath, b, 0600)
+		return fpath
+	}
+	for _, fpath := range []string{
+		write("raw", key),
+		write("hex", []byte(hex.EncodeToString(key)+"\n")),
+	} {
+		got, err := LoadKeyFile(fpath)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("%s: %v", fpath, err)
+		}
+	}
+	if _, err := LoadKeyFile(write("short", []byte("abc"))); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
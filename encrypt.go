@@ -0,0 +1,207 @@
+package upx
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+	"github.com/upyun/upx/crypt"
+	"github.com/upyun/upx/processbar"
+	"github.com/vbauerster/mpb/v8"
+)
+
+// --encrypt 加密上传，加密参数保存在元信息中，数据密钥由 --key-file 指定的主密钥加密
+const (
+	EncryptAlgorithm = "aes-256-gcm-chunked"
+
+	EncryptMetaHeader   = MetaHeaderPrefix + "Encrypt"
+	KeyIDMetaHeader     = MetaHeaderPrefix + "Key-Id"
+	DataKeyMetaHeader   = MetaHeaderPrefix + "Data-Key"
+	ChunkSizeMetaHeader = MetaHeaderPrefix + "Chunk-Size"
+)
+
+// 生成新的数据密钥，返回数据密钥和需要保存的元信息
+func (sess *Session) newDataKey() ([]byte, map[string]string, error) {
+	dataKey, err := crypt.NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := crypt.WrapKey(sess.encryptKey, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, map[string]string{
+		EncryptMetaHeader:   EncryptAlgorithm,
+		KeyIDMetaHeader:     crypt.KeyID(sess.encryptKey),
+		DataKeyMetaHeader:   base64.StdEncoding.EncodeToString(wrapped),
+		ChunkSizeMetaHeader: fmt.Sprint(crypt.DefaultChunkSize),
+	}, nil
+}
+
+// 使用主密钥解开元信息中的数据密钥，同时返回加密的分块大小
+func (sess *Session) unwrapDataKey(meta map[string]string) ([]byte, int, error) {
+	if algo, _ := metaValue(meta, EncryptMetaHeader); algo != EncryptAlgorithm {
+		return nil, 0, fmt.Errorf("unsupported encryption %s", algo)
+	}
+	if sess.encryptKey == nil {
+		return nil, 0, fmt.Errorf("file is encrypted, use --key-file to decrypt")
+	}
+	if id, _ := metaValue(meta, KeyIDMetaHeader); id != crypt.KeyID(sess.encryptKey) {
+		return nil, 0, fmt.Errorf("encrypted with key %s: %w", id, crypt.ErrWrongKey)
+	}
+	v, _ := metaValue(meta, DataKeyMetaHeader)
+	wrapped, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid data key: %v", err)
+	}
+	dataKey, err := crypt.UnwrapKey(sess.encryptKey, wrapped)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, _ = metaValue(meta, ChunkSizeMetaHeader)
+	chunkSize, err := strconv.Atoi(v)
+	if err != nil || chunkSize <= 0 {
+		return nil, 0, fmt.Errorf("invalid chunk size %q", v)
+	}
+	return dataKey, chunkSize, nil
+}
+
+// 加密本地文件，prev 为断点续传记录中保存的元信息，能解开时继续使用原来的数据密钥，
+// 这样续传时同一位置的密文与之前上传的相同
+func (sess *Session) encryptFile(r io.ReaderAt, localInfo os.FileInfo, prev map[string]string) (*crypt.Encrypter, map[string]string, error) {
+	var (
+		dataKey []byte
+		meta    map[string]string
+		err     error
+	)
+	if prev != nil {
+		if dataKey, _, err = sess.unwrapDataKey(prev); err == nil {
+			meta = prev
+		}
+	}
+	if meta == nil {
+		if dataKey, meta, err = sess.newDataKey(); err != nil {
+			return nil, nil, err
+		}
+		meta[OriginalSizeMetaHeader] = fmt.Sprint(localInfo.Size())
+	}
+	enc, err := crypt.NewEncrypter(dataKey, r, localInfo.Size(), crypt.DefaultChunkSize)
+	return enc, meta, err
+}
+
+// 加密大小事先未知的数据流，加密参数合并到 meta 中
+func (sess *Session) encryptStream(r io.Reader, meta map[string]string) (io.Reader, map[string]string, error) {
+	dataKey, encMeta, err := sess.newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range meta {
+		encMeta[k] = v
+	}
+	er, err := crypt.NewEncryptReader(dataKey, r, crypt.DefaultChunkSize)
+	return er, encMeta, err
+}
+
+// 加密上传本地文件，大文件分片上传，断点续传见 putMultipart
+func (sess *Session) putEncryptedFile(localPath, upPath string, localInfo os.FileInfo) error {
+	fd, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	size := crypt.EncryptedSize(localInfo.Size(), crypt.DefaultChunkSize)
+	var bar *mpb.Bar
+	if IsVerbose {
+		bar = processbar.ProcessBar.AddBar(upPath, size)
+	}
+	if size >= MinResumePutFileSize {
+		err = sess.putMultipart(fd, upPath, localInfo, bar, "")
+	} else {
+		err = sess.putEncryptedSmallFile(fd, upPath, localInfo, bar)
+	}
+	if bar != nil {
+		bar.EnableTriggerComplete()
+		if err != nil {
+			bar.Abort(false)
+		}
+	}
+	return err
+}
+
+func (sess *Session) putEncryptedSmallFile(fd *os.File, upPath string, localInfo os.FileInfo, bar *mpb.Bar) error {
+	enc, meta, err := sess.encryptFile(fd, localInfo, nil)
+	if err != nil {
+		return err
+	}
+	cfg := &upyun.PutObjectConfig{
+		Path: upPath,
+		Headers: map[string]string{
+			"Content-Length": fmt.Sprint(enc.Size()),
+			"Content-Type":   octetStream,
+		},
+	}
+	sess.addUploadHeaders(upPath, cfg.Headers)
+	for k, v := range sess.preserveHeaders(localInfo) {
+		cfg.Headers[k] = v
+	}
+	for k, v := range meta {
+		cfg.Headers[k] = v
+	}
+
+	var encMD5 string
+	if sess.verify {
+		if encMD5, err = md5Reader(io.NewSectionReader(enc, 0, enc.Size())); err != nil {
+			return err
+		}
+		cfg.Headers["Content-MD5"] = encMD5
+	}
+	var r io.Reader = sess.limiter.Reader(io.NewSectionReader(enc, 0, enc.Size()))
+	if bar != nil {
+		r = bar.ProxyReader(r)
+	}
+	cfg.Reader = r
+	if err = sess.updriver.Put(cfg); err == nil && sess.verify {
+		err = sess.verifyUpload(upPath, encMD5)
+	}
+	return err
+}
+
+func md5Reader(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// 下载前确认能够解密，避免下载完成后才发现没有密钥
+func (sess *Session) checkDecrypt(upInfo *upyun.FileInfo) error {
+	if _, ok := metaValue(upInfo.Meta, EncryptMetaHeader); !ok {
+		return nil
+	}
+	_, _, err := sess.unwrapDataKey(upInfo.Meta)
+	return err
+}
+
+// 下载完成后解密加密上传的文件
+func (sess *Session) decryptFile(localPath string, upInfo *upyun.FileInfo) error {
+	if _, ok := metaValue(upInfo.Meta, EncryptMetaHeader); !ok {
+		return nil
+	}
+	dataKey, chunkSize, err := sess.unwrapDataKey(upInfo.Meta)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %v", localPath, err)
+	}
+	return rewriteFile(localPath, func(w io.Writer, r io.Reader) error {
+		if err := crypt.Decrypt(dataKey, w, bufio.NewReader(r), chunkSize); err != nil {
+			return fmt.Errorf("decrypt %s: %v", localPath, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package upx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/upyun/go-sdk/v3/upyun"
+	"github.com/upyun/upx/crypt"
+)
+
+func TestEncryptFileRoundTrip(t *testing.T) {
+	key, _ := crypt.NewDataKey()
+	sess := &Session{encrypt: true, encryptKey: key}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dump.sql")
+	content := []byte(strings.Repeat("INSERT INTO t VALUES (1);\n", 10000))
+	assert.NoError(t, os.WriteFile(src, content, 0644))
+	fd, err := os.Open(src)
+	assert.NoError(t, err)
+	defer fd.Close()
+	info, _ := fd.Stat()
+
+	enc, meta, err := sess.encryptFile(fd, info, nil)
+	assert.NoError(t, err)
+	sealed, err := io.ReadAll(io.NewSectionReader(enc, 0, enc.Size()))
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(sealed, []byte("INSERT")))
+
+	// 续传时使用记录中的数据密钥，得到相同的密文
+	enc2, meta2, err := sess.encryptFile(fd, info, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, meta, meta2)
+	sealed2, _ := io.ReadAll(io.NewSectionReader(enc2, 0, enc2.Size()))
+	assert.Equal(t, sealed, sealed2)
+
+	// 云端返回的元信息 key 为小写
+	upInfo := &upyun.FileInfo{Size: int64(len(sealed)), Meta: map[string]string{}}
+	for k, v := range meta {
+		upInfo.Meta[strings.ToLower(k)] = v
+	}
+	assert.Equal(t, int64(len(content)), originalSize(upInfo))
+
+	dst := filepath.Join(dir, "dump.sql.download")
+	assert.NoError(t, os.WriteFile(dst, sealed, 0644))
+	assert.NoError(t, sess.checkDecrypt(upInfo))
+	assert.NoError(t, sess.decryptFile(dst, upInfo))
+	got, _ := os.ReadFile(dst)
+	assert.Equal(t, content, got)
+
+	// 没有密钥或者密钥不对时下载前报错
+	assert.Error(t, (&Session{}).checkDecrypt(upInfo))
+	other, _ := crypt.NewDataKey()
+	err = (&Session{encryptKey: other}).checkDecrypt(upInfo)
+	assert.True(t, errors.Is(err, crypt.ErrWrongKey))
+}
@@ -0,0 +1,318 @@
+package upx
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+const (
+	// 又拍云异步拉取文件的处理程序
+	fetchAppName = "spiderman"
+
+	// 每次提交的任务数
+	fetchBatchSize = 20
+
+	defaultNotifyURL = "https://httpbin.org/post"
+)
+
+// 一个异步拉取任务，由云存储直接从 URL 下载文件并保存到 SaveAs
+type fetchTask struct {
+	URL    string
+	SaveAs string
+	TaskID string
+
+	Done bool
+	Err  string
+}
+
+type FetchConfig struct {
+	// 任务完成后的回调地址
+	Notify string
+
+	// 本地监听回调的地址，为空时轮询任务进度
+	Listen string
+
+	Wait         bool
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+// 读取批量拉取的 URL 列表，每行为 "url [save-path]"，未指定保存路径时保存到 upPath 目录下
+func parseFetchList(file, upPath string) ([]*fetchTask, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var tasks []*fetchTask
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		saveAs := ""
+		if len(fields) > 1 {
+			saveAs = fields[1]
+		}
+		task, err := newFetchTask(fields[0], upPath, saveAs)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, scanner.Err()
+}
+
+// saveAs 为空时使用 URL 中的文件名保存到 upPath 目录下
+func newFetchTask(rawURL, upPath, saveAs string) (*fetchTask, error) {
+	fileURL, err := url.ParseRequestURI(rawURL)
+	if err != nil || !contains([]string{"http", "https"}, fileURL.Scheme) || fileURL.Host == "" {
+		return nil, fmt.Errorf("invalid URL %s", rawURL)
+	}
+	if saveAs == "" {
+		name := path.Base(fileURL.Path)
+		if name == "/" || name == "." {
+			return nil, fmt.Errorf("missing file name in the url %s, must has remote path name", rawURL)
+		}
+		saveAs = path.Join(upPath, name)
+	}
+	return &fetchTask{URL: rawURL, SaveAs: saveAs}, nil
+}
+
+func (sess *Session) AsyncFetch(tasks []*fetchTask, cfg *FetchConfig) {
+	var results chan map[string]interface{}
+	if cfg.Wait && cfg.Listen != "" {
+		if cfg.Notify == "" {
+			notify, err := fetchNotifyURL(cfg.Listen)
+			if err != nil {
+				PrintErrorAndExit("%v", err)
+			}
+			cfg.Notify = notify
+		}
+		var (
+			stop func()
+			err  error
+		)
+		if results, stop, err = listenFetchNotify(cfg.Listen); err != nil {
+			PrintErrorAndExit("listen %s: %v", cfg.Listen, err)
+		}
+		defer stop()
+	}
+	if cfg.Notify == "" {
+		cfg.Notify = defaultNotifyURL
+	}
+
+	for i := 0; i < len(tasks); i += fetchBatchSize {
+		batch := tasks[i:min(i+fetchBatchSize, len(tasks))]
+		var payload []interface{}
+		for _, task := range batch {
+			task.SaveAs = sess.AbsPath(task.SaveAs)
+			payload = append(payload, map[string]interface{}{
+				"url":       task.URL,
+				"save_as":   task.SaveAs,
+				"overwrite": true,
+				"random":    false,
+			})
+		}
+		ids, err := sess.updriver.CommitTasks(&upyun.CommitTasksConfig{
+			AppName:   fetchAppName,
+			NotifyUrl: cfg.Notify,
+			Tasks:     payload,
+		})
+		if err != nil {
+			PrintErrorAndExit("commit fetch tasks: %v", err)
+		}
+		for j, id := range ids {
+			if j < len(batch) {
+				batch[j].TaskID = id
+			}
+		}
+	}
+
+	if !cfg.Wait {
+		for _, task := range tasks {
+			Print("%s => %s: submitted %s", task.URL, task.SaveAs, task.TaskID)
+		}
+		return
+	}
+
+	if results != nil {
+		waitFetchNotify(tasks, results, cfg.Timeout)
+	} else {
+		sess.pollFetchTasks(tasks, cfg.PollInterval, cfg.Timeout)
+	}
+
+	failed := 0
+	for _, task := range tasks {
+		switch {
+		case !task.Done:
+			failed++
+			PrintError("%s => %s: timeout, task %s", task.URL, task.SaveAs, task.TaskID)
+		case task.Err != "":
+			failed++
+			PrintError("%s => %s: failed: %s", task.URL, task.SaveAs, task.Err)
+		default:
+			Print("%s => %s: OK", task.URL, task.SaveAs)
+		}
+	}
+	if failed > 0 {
+		PrintErrorAndExit("%d of %d fetch tasks failed", failed, len(tasks))
+	}
+}
+
+// 未指定 --notify 时由监听地址得到回调地址，云存储无法访问未指定主机或回环地址
+func fetchNotifyURL(listen string) (string, error) {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid --notify-listen %s: %v", listen, err)
+	}
+	ip := net.ParseIP(host)
+	if host == "" || host == "localhost" || ip != nil && (ip.IsUnspecified() || ip.IsLoopback()) {
+		return "", fmt.Errorf("--notify-listen %s is not reachable by the storage service, set --notify to its public url", listen)
+	}
+	return "http://" + listen + "/", nil
+}
+
+// 启动本地 HTTP 服务接收任务完成的回调，返回的函数用于关闭服务
+func listenFetchNotify(addr string) (chan map[string]interface{}, func(), error) {
+	results := make(chan map[string]interface{}, fetchBatchSize)
+	done := make(chan struct{})
+	server := &http.Server{
+		Addr:    addr,
+		Handler: fetchNotifyHandler(results, done),
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errChan:
+		return nil, nil, err
+	case <-time.After(100 * time.Millisecond):
+	}
+	stop := func() {
+		close(done)
+		server.Close()
+	}
+	return results, stop, nil
+}
+
+// 回调的内容发送到 results，done 关闭后不再等待接收，回调直接返回
+func fetchNotifyHandler(results chan<- map[string]interface{}, done <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			values, _ := url.ParseQuery(string(body))
+			for k := range values {
+				result[k] = values.Get(k)
+			}
+		}
+		select {
+		case results <- result:
+		case <-done:
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func waitFetchNotify(tasks []*fetchTask, results chan map[string]interface{}, timeout time.Duration) {
+	pending := map[string]*fetchTask{}
+	for _, task := range tasks {
+		pending[task.TaskID] = task
+	}
+	deadline := time.After(timeout)
+	for len(pending) > 0 {
+		select {
+		case result := <-results:
+			id, _ := result["task_id"].(string)
+			if task, ok := pending[id]; ok {
+				task.Done, task.Err = true, fetchResultError(result)
+				delete(pending, id)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// 轮询任务进度，完成的任务再获取处理结果
+func (sess *Session) pollFetchTasks(tasks []*fetchTask, interval, timeout time.Duration) {
+	pending := map[string]*fetchTask{}
+	for _, task := range tasks {
+		pending[task.TaskID] = task
+	}
+	deadline := time.Now().Add(timeout)
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var ids []string
+		for id := range pending {
+			ids = append(ids, id)
+		}
+		progress, err := sess.updriver.GetProgress(ids)
+		if err != nil {
+			PrintError("get fetch progress: %v", err)
+			continue
+		}
+
+		var finished []string
+		for id, p := range progress {
+			if p >= 100 || p < 0 {
+				finished = append(finished, id)
+			}
+		}
+		if len(finished) == 0 {
+			continue
+		}
+		results, err := sess.updriver.GetResult(finished)
+		if err != nil {
+			PrintError("get fetch result: %v", err)
+			continue
+		}
+		for _, id := range finished {
+			task := pending[id]
+			if task == nil {
+				continue
+			}
+			result, _ := results[id].(map[string]interface{})
+			task.Done, task.Err = true, fetchResultError(result)
+			if progress[id] < 0 && task.Err == "" {
+				task.Err = "task failed"
+			}
+			delete(pending, id)
+		}
+	}
+}
+
+// 从回调或处理结果中获取错误信息，成功时返回空字符串
+func fetchResultError(result map[string]interface{}) string {
+	if msg, ok := result["error"].(string); ok && msg != "" {
+		return msg
+	}
+	var code int
+	switch v := result["status_code"].(type) {
+	case float64:
+		code = int(v)
+	case string:
+		code, _ = strconv.Atoi(v)
+	}
+	if code != 0 && code != http.StatusOK {
+		return fmt.Sprintf("status code %d", code)
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package upx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFetchList(t *testing.T) {
+	listFile := filepath.Join(t.TempDir(), "urls")
+	os.WriteFile(listFile, []byte(`# 注释和空行会被忽略
+https://example.com/a/1.jpg
+
+http://example.com/2.png  /other/2.png
+`), 0644)
+
+	tasks, err := parseFetchList(listFile, "/images")
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, "https://example.com/a/1.jpg", tasks[0].URL)
+	assert.Equal(t, "/images/1.jpg", tasks[0].SaveAs)
+	assert.Equal(t, "/other/2.png", tasks[1].SaveAs)
+
+	os.WriteFile(listFile, []byte("ftp://example.com/1.jpg\n"), 0644)
+	_, err = parseFetchList(listFile, "/images")
+	assert.Error(t, err)
+
+	_, err = parseFetchList(filepath.Join(t.TempDir(), "not-exists"), "/images")
+	assert.Error(t, err)
+}
+
+func TestNewFetchTask(t *testing.T) {
+	task, err := newFetchTask("https://example.com/a/b.zip?v=1", "/dl", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dl/b.zip", task.SaveAs)
+
+	task, err = newFetchTask("https://example.com/", "/dl", "/dl/index.html")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dl/index.html", task.SaveAs)
+
+	for _, u := range []string{"example.com/a.zip", "file:///a.zip", "https:///a.zip", "https://example.com/"} {
+		_, err := newFetchTask(u, "/dl", "")
+		assert.Error(t, err, u)
+	}
+}
+
+func TestFetchResultError(t *testing.T) {
+	assert.Equal(t, "", fetchResultError(nil))
+	assert.Equal(t, "", fetchResultError(map[string]interface{}{"status_code": float64(200)}))
+	assert.Equal(t, "", fetchResultError(map[string]interface{}{"status_code": "200"}))
+	assert.Equal(t, "status code 404", fetchResultError(map[string]interface{}{"status_code": float64(404)}))
+	assert.Equal(t, "status code 502", fetchResultError(map[string]interface{}{"status_code": "502"}))
+	assert.Equal(t, "timeout", fetchResultError(map[string]interface{}{"error": "timeout", "status_code": "200"}))
+}
+
+func TestFetchNotifyURL(t *testing.T) {
+	u, err := fetchNotifyURL("203.0.113.1:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://203.0.113.1:8080/", u)
+
+	for _, addr := range []string{":8080", "0.0.0.0:8080", "[::]:8080", "127.0.0.1:8080", "localhost:8080", "8080"} {
+		_, err := fetchNotifyURL(addr)
+		assert.Error(t, err, addr)
+	}
+}
+
+func TestFetchNotifyHandler(t *testing.T) {
+	results := make(chan map[string]interface{}, 1)
+	done := make(chan struct{})
+	ts := httptest.NewServer(fetchNotifyHandler(results, done))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"task_id":"1","status_code":200}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "1", (<-results)["task_id"])
+
+	resp, err = http.Post(ts.URL, "application/x-www-form-urlencoded", strings.NewReader("task_id=2&error=timeout"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "timeout", fetchResultError(<-results))
+
+	// 不再等待回调后，results 已满也不会阻塞
+	results <- nil
+	close(done)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err = client.Post(ts.URL, "application/json", strings.NewReader(`{"task_id":"3"}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
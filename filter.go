@@ -0,0 +1,176 @@
+package upx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FilterRule 是一条 rsync 风格的 include/exclude 规则
+//   - 不含 / 的模式只匹配文件名
+//   - 含 / 的模式匹配完整的相对路径，以 / 开头表示从根目录锚定
+//   - 以 / 结尾的模式只匹配目录
+type FilterRule struct {
+	Include bool
+	Pattern string
+}
+
+func NewFilterRule(include bool, pattern string) (*FilterRule, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("empty filter pattern")
+	}
+	if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+		return nil, fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+	}
+	return &FilterRule{Include: include, Pattern: pattern}, nil
+}
+
+func (r *FilterRule) Match(relPath string, isDir bool) bool {
+	pattern := r.Pattern
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	relPath = strings.Trim(relPath, "/")
+	if strings.HasPrefix(pattern, "/") {
+		same, _ := path.Match(strings.TrimPrefix(pattern, "/"), relPath)
+		return same
+	}
+	if !strings.Contains(pattern, "/") {
+		same, _ := path.Match(pattern, path.Base(relPath))
+		return same
+	}
+
+	// 未锚定的路径模式可以匹配任意一层目录开始的后缀
+	parts := strings.Split(relPath, "/")
+	for i := range parts {
+		if same, _ := path.Match(pattern, strings.Join(parts[i:], "/")); same {
+			return true
+		}
+	}
+	return false
+}
+
+// 按顺序检查规则，第一条命中的规则决定是否包含；都没有命中则包含
+// 如果某一级父目录被排除，则其下所有内容都被排除
+func matchFilterRules(rules []*FilterRule, relPath string, isDir bool) bool {
+	relPath = strings.Trim(relPath, "/")
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if !matchFilterRule(rules, strings.Join(parts[:i], "/"), true) {
+			return false
+		}
+	}
+	return matchFilterRule(rules, relPath, isDir)
+}
+
+func matchFilterRule(rules []*FilterRule, relPath string, isDir bool) bool {
+	for _, rule := range rules {
+		if rule.Match(relPath, isDir) {
+			return rule.Include
+		}
+	}
+	return true
+}
+
+// 解析过滤规则文件，每行一条规则，"+ pattern" 或 "include pattern" 表示包含，
+// "- pattern" 或 "exclude pattern" 表示排除，以 # 或 ; 开头的行为注释
+func parseFilterFile(filename string) ([]*FilterRule, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var rules []*FilterRule
+	scanner := bufio.NewScanner(fd)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		var include bool
+		var pattern string
+		switch {
+		case strings.HasPrefix(line, "+ "):
+			include, pattern = true, line[2:]
+		case strings.HasPrefix(line, "- "):
+			include, pattern = false, line[2:]
+		case strings.HasPrefix(line, "include "):
+			include, pattern = true, line[len("include "):]
+		case strings.HasPrefix(line, "exclude "):
+			include, pattern = false, line[len("exclude "):]
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown filter rule %q", filename, lineNo, line)
+		}
+
+		rule, err := NewFilterRule(include, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// filterFlag 实现 cli.Generic，--include/--exclude/--filter-from 共享同一个
+// 规则列表，从而保留它们在命令行中出现的先后顺序
+type filterFlag struct {
+	rules *[]*FilterRule
+	kind  string
+}
+
+func (f *filterFlag) Set(value string) error {
+	switch f.kind {
+	case "filter-from":
+		rules, err := parseFilterFile(value)
+		if err != nil {
+			return err
+		}
+		*f.rules = append(*f.rules, rules...)
+	default:
+		rule, err := NewFilterRule(f.kind == "include", value)
+		if err != nil {
+			return err
+		}
+		*f.rules = append(*f.rules, rule)
+	}
+	return nil
+}
+
+func (f *filterFlag) String() string {
+	return ""
+}
+
+// 多个 --regex/--iregex 需要同时满足
+type regexFlag struct {
+	regexps    *[]*regexp.Regexp
+	ignoreCase bool
+}
+
+func (f *regexFlag) Set(value string) error {
+	expr := "^(?:" + value + ")$"
+	if f.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	*f.regexps = append(*f.regexps, re)
+	return nil
+}
+
+func (f *regexFlag) String() string {
+	return ""
+}
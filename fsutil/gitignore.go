@@ -0,0 +1,224 @@
+package fsutil
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 每个目录下的忽略规则文件，语法同 .gitignore
+const IgnoreFileName = ".upxignore"
+
+type ignoreRule struct {
+	// 规则所在的目录，相对 Ignorer 的根目录，根目录为 ""
+	base     string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// 解析一行 gitignore 规则，空行和注释返回 nil
+func parseIgnoreRule(line, base string) *ignoreRule {
+	line = strings.TrimRight(line, "\r")
+	// 行尾的空格需要使用 \ 转义
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	rule := &ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	// 包含 / 的规则相对所在目录匹配，否则匹配任意层级的文件名
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return nil
+	}
+	rule.re = re
+	return rule
+}
+
+// 将 gitignore 的通配符转换为正则表达式，* 和 ? 不匹配 /，** 匹配任意层级的目录
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ 匹配零个或多个目录
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// rel 为相对 Ignorer 根目录的路径，使用 / 分隔
+func (r *ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if !r.anchored {
+		rel = rel[strings.LastIndex(rel, "/")+1:]
+	}
+	return r.re.MatchString(rel)
+}
+
+// Ignorer 按 gitignore 的语义判断根目录下的文件是否需要忽略
+// 规则的优先级从低到高为：忽略规则文件、各级目录下的 .upxignore (越深的目录优先级越高)、命令行指定的规则
+// 同一来源中后面的规则优先，被忽略的目录中的文件无法通过 ! 重新包含
+type Ignorer struct {
+	root     string
+	files    []*ignoreRule
+	excludes []*ignoreRule
+
+	mu   sync.Mutex
+	dirs map[string][]*ignoreRule
+}
+
+// excludes 为命令行指定的规则，ignoreFiles 为额外的规则文件，其中的规则相对根目录匹配
+func NewIgnorer(root string, excludes, ignoreFiles []string) (*Ignorer, error) {
+	ig := &Ignorer{
+		root: root,
+		dirs: make(map[string][]*ignoreRule),
+	}
+	for _, file := range ignoreFiles {
+		rules, err := readIgnoreFile(file, "")
+		if err != nil {
+			return nil, err
+		}
+		ig.files = append(ig.files, rules...)
+	}
+	for _, pattern := range excludes {
+		if rule := parseIgnoreRule(pattern, ""); rule != nil {
+			ig.excludes = append(ig.excludes, rule)
+		}
+	}
+	return ig, nil
+}
+
+func readIgnoreFile(file, base string) ([]*ignoreRule, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if rule := parseIgnoreRule(scanner.Text(), base); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// 读取目录 dir 下的 .upxignore，dir 为相对根目录的路径
+func (ig *Ignorer) dirRules(dir string) []*ignoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if rules, ok := ig.dirs[dir]; ok {
+		return rules
+	}
+	rules, _ := readIgnoreFile(filepath.Join(ig.root, filepath.FromSlash(dir), IgnoreFileName), dir)
+	ig.dirs[dir] = rules
+	return rules
+}
+
+// fpath 为根目录下的文件或目录，不在根目录下的路径不会被忽略
+func (ig *Ignorer) Ignored(fpath string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	rel, err := filepath.Rel(ig.root, fpath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	// 上级目录被忽略时，目录中的文件也被忽略
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.match(rel, isDir)
+}
+
+func (ig *Ignorer) match(rel string, isDir bool) bool {
+	rules := append([]*ignoreRule{}, ig.files...)
+	rules = append(rules, ig.dirRules("")...)
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		rules = append(rules, ig.dirRules(strings.Join(parts[:i], "/"))...)
+	}
+	rules = append(rules, ig.excludes...)
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(rel, isDir) {
+			return !rules[i].negate
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnorerPatterns(t *testing.T) {
+	ig, err := NewIgnorer("/root", []string{
+		"*.tmp",
+		"!keep.tmp",
+		"/build",
+		"cache/",
+		"docs/**/*.pdf",
+		"**/logs",
+		"a/**",
+		"file[0-9].txt",
+		`\#hash`,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"x.tmp", false, true},
+		{"sub/x.tmp", false, true},
+		{"sub/keep.tmp", false, false},
+		{"build", true, true},
+		{"build/out.o", false, true},
+		{"sub/build", true, false},
+		{"cache", false, false},
+		{"cache", true, true},
+		{"sub/cache/x", false, true},
+		{"docs/a.pdf", false, true},
+		{"docs/x/y/a.pdf", false, true},
+		{"other/docs/a.pdf", false, false},
+		{"logs", true, true},
+		{"x/y/logs/1", false, true},
+		{"a", true, false},
+		{"a/b/c", false, true},
+		{"file1.txt", false, true},
+		{"fileA.txt", false, false},
+		{"#hash", false, true},
+		{"main.go", false, false},
+	}
+	for _, c := range cases {
+		if got := ig.Ignored(filepath.Join("/root", filepath.FromSlash(c.path)), c.isDir); got != c.ignored {
+			t.Errorf("%s (dir %v): ignored %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestIgnorerFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		fpath := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(IgnoreFileName, "# comment\n*.log\nnode_modules/\n")
+	write("web/"+IgnoreFileName, "!important.log\n/dist\n")
+	write("extra.ignore", "*.swp\n")
+
+	ig, err := NewIgnorer(root, []string{"secret.txt"}, []string{filepath.Join(root, "extra.ignore")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"web/a.log", false, true},
+		{"web/important.log", false, false},
+		{"important.log", false, true},
+		{"web/node_modules/x.js", false, true},
+		{"web/dist", true, true},
+		{"dist", true, false},
+		{"web/sub/dist", true, false},
+		{"x.swp", false, true},
+		{"web/secret.txt", false, true},
+		{"web/index.html", false, false},
+	}
+	for _, c := range cases {
+		if got := ig.Ignored(filepath.Join(root, filepath.FromSlash(c.path)), c.isDir); got != c.ignored {
+			t.Errorf("%s (dir %v): ignored %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+
+	if _, err := NewIgnorer(root, nil, []string{filepath.Join(root, "missing")}); err == nil {
+		t.Fatal("expected error for missing ignore file")
+	}
+}
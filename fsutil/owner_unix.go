@@ -0,0 +1,23 @@
+//go:build linux || darwin
+
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// 获取文件的属主
+func FileOwner(fileInfo fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// 修改文件的属主，通常需要 root 权限
+func SetFileOwner(path string, uid, gid int) error {
+	return os.Lchown(path, uid, gid)
+}
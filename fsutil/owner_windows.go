@@ -0,0 +1,16 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"io/fs"
+)
+
+// Windows 没有 uid/gid
+func FileOwner(fileInfo fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func SetFileOwner(path string, uid, gid int) error {
+	return nil
+}
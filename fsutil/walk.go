@@ -0,0 +1,99 @@
+package fsutil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// 符号链接指向正在遍历的祖先目录，继续进入会形成环
+var ErrSymlinkLoop = errors.New("symlink loop")
+
+// 判断是否是管道、套接字、设备等特殊文件，读取这些文件可能会一直阻塞
+func IsSpecialFile(info fs.FileInfo) bool {
+	return info.Mode()&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeDevice|fs.ModeCharDevice|fs.ModeIrregular) != 0
+}
+
+// 同 filepath.Walk，followLinks 为 true 时会进入指向目录的符号链接
+// 跟随链接时传给 fn 的是链接目标的信息，目标不存在时传入链接本身的信息和错误，
+// 指向祖先目录的链接传入 ErrSymlinkLoop，不会进入该目录
+func Walk(root string, followLinks bool, fn filepath.WalkFunc) error {
+	if !followLinks {
+		return filepath.Walk(root, fn)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(root, info, nil, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// parents 为祖先目录的真实路径
+func walk(fpath string, info fs.FileInfo, parents []string, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(fpath, info, nil)
+	}
+
+	real, err := filepath.EvalSymlinks(fpath)
+	if err == nil {
+		real, err = filepath.Abs(real)
+	}
+	if err != nil {
+		return fn(fpath, info, err)
+	}
+	for _, p := range parents {
+		if p == real {
+			if err := fn(fpath, info, ErrSymlinkLoop); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			return nil
+		}
+	}
+
+	if err := fn(fpath, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	entries, err := os.ReadDir(fpath)
+	if err != nil {
+		if err := fn(fpath, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	parents = append(parents, real)
+	for _, entry := range entries {
+		name := filepath.Join(fpath, entry.Name())
+		fi, err := os.Stat(name)
+		if err != nil {
+			// 目标不存在的符号链接
+			if lfi, lerr := os.Lstat(name); lerr == nil {
+				fi = lfi
+			}
+			if err := fn(name, fi, err); err != nil {
+				if err == filepath.SkipDir {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		if err := walk(name, fi, parents, fn); err != nil {
+			// 目录返回的 SkipDir 已经在 walk 中处理，这里只会是文件返回的，跳过剩下的文件
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package fsutil
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestWalkFollowLinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	os.MkdirAll(filepath.Join(root, "other"), 0755)
+	os.WriteFile(filepath.Join(root, "a", "b", "f"), []byte("f"), 0644)
+	os.WriteFile(filepath.Join(root, "other", "g"), []byte("g"), 0644)
+	if err := os.Symlink(filepath.Join(root, "other"), filepath.Join(root, "a", "other")); err != nil {
+		t.Skipf("symlink not supported: %v", err)
+	}
+	os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "a", "b", "loop"))
+	os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "a", "broken"))
+
+	walkPaths := func(follow bool) (files, loops, broken []string) {
+		err := Walk(filepath.Join(root, "a"), follow, func(fpath string, info fs.FileInfo, err error) error {
+			rel, _ := filepath.Rel(root, fpath)
+			rel = filepath.ToSlash(rel)
+			switch {
+			case errors.Is(err, ErrSymlinkLoop):
+				loops = append(loops, rel)
+			case err != nil:
+				broken = append(broken, rel)
+			case !info.IsDir():
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(files)
+		return
+	}
+
+	files, loops, broken := walkPaths(true)
+	if want := []string{"a/b/f", "a/other/g"}; !equal(files, want) {
+		t.Errorf("follow files %v, want %v", files, want)
+	}
+	if want := []string{"a/b/loop"}; !equal(loops, want) {
+		t.Errorf("follow loops %v, want %v", loops, want)
+	}
+	if want := []string{"a/broken"}; !equal(broken, want) {
+		t.Errorf("follow broken %v, want %v", broken, want)
+	}
+
+	// 不跟随时链接本身作为文件
+	files, loops, _ = walkPaths(false)
+	if want := []string{"a/b/f", "a/b/loop", "a/broken", "a/other"}; !equal(files, want) {
+		t.Errorf("files %v, want %v", files, want)
+	}
+	if len(loops) != 0 {
+		t.Errorf("unexpected loops %v", loops)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
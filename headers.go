@@ -0,0 +1,176 @@
+package upx
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+// 自定义元信息的前缀，--meta k=v 对应 x-upyun-meta-k
+const MetaHeaderPrefix = "X-Upyun-Meta-"
+
+// 由上传过程计算的头，不允许自定义
+var reservedHeaders = []string{"Content-Length", "Content-Md5"}
+
+// 规则文件中的一条规则，按远程路径匹配，模式的语法同 --include/--exclude
+type headerRule struct {
+	rule    *FilterRule
+	headers map[string]string
+}
+
+// 以 / 结尾的目录规则对目录下所有层级的文件生效，其他规则只匹配文件本身
+func (r *headerRule) match(upPath string) bool {
+	if r.rule.Match(upPath, false) {
+		return true
+	}
+	if !strings.HasSuffix(r.rule.Pattern, "/") {
+		return false
+	}
+	parts := strings.Split(strings.Trim(upPath, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		if r.rule.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return false
+}
+
+// 上传时附加的 HTTP 头，命令行参数的优先级高于规则文件，规则文件中后面的规则优先
+type UploadHeaders struct {
+	rules   []*headerRule
+	headers map[string]string
+}
+
+// 解析 "Key: Value" 形式的头
+func parseHeader(value string) (string, string, error) {
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("invalid header %q, must be Key:Value", value)
+	}
+	key := http.CanonicalHeaderKey(strings.TrimSpace(kv[0]))
+	if contains(reservedHeaders, key) {
+		return "", "", fmt.Errorf("header %s can't be set", key)
+	}
+	return key, strings.TrimSpace(kv[1]), nil
+}
+
+// 解析 "key=value" 形式的元信息
+func parseMeta(value string) (string, string, error) {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("invalid meta %q, must be key=value", value)
+	}
+	return http.CanonicalHeaderKey(MetaHeaderPrefix + strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1]), nil
+}
+
+// 解析头规则文件，每行为 "pattern Key: Value"，同一个模式可以写多行，以 # 开头的行为注释
+//
+//	*.js     Cache-Control: max-age=31536000
+//	*.html   Cache-Control: no-cache
+//	/dl/     Content-Disposition: attachment
+func parseHeaderRules(filename string) ([]*headerRule, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var rules []*headerRule
+	scanner := bufio.NewScanner(fd)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			fields = strings.SplitN(line, "\t", 2)
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: missing header", filename, n)
+		}
+		rule, err := NewFilterRule(true, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		key, value, err := parseHeader(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		rules = append(rules, &headerRule{rule: rule, headers: map[string]string{key: value}})
+	}
+	return rules, scanner.Err()
+}
+
+// 远程文件 upPath 需要附加的头，没有时返回 nil
+func (h *UploadHeaders) For(upPath string) map[string]string {
+	if h == nil {
+		return nil
+	}
+	var headers map[string]string
+	set := func(m map[string]string) {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		for k, v := range m {
+			headers[k] = v
+		}
+	}
+	for _, r := range h.rules {
+		if r.match(upPath) {
+			set(r.headers)
+		}
+	}
+	if len(h.headers) > 0 {
+		set(h.headers)
+	}
+	return headers
+}
+
+// 将 upPath 需要附加的头合并到 headers 中
+func (sess *Session) addUploadHeaders(upPath string, headers map[string]string) {
+	for k, v := range sess.headers.For(upPath) {
+		headers[k] = v
+	}
+}
+
+// 分片上传只能在初始化时指定 Content-Type，其他的头在合并完成后通过修改元信息设置
+// extra 为额外需要设置的元信息
+func (sess *Session) setMultipartHeaders(upPath string, extra map[string]string) error {
+	headers := make(map[string]string)
+	sess.addUploadHeaders(upPath, headers)
+	for k, v := range extra {
+		headers[k] = v
+	}
+	delete(headers, "Content-Type")
+	if len(headers) == 0 {
+		return nil
+	}
+	return sess.updriver.ModifyMetadata(&upyun.ModifyMetadataConfig{
+		Path:      upPath,
+		Operation: "merge",
+		Headers:   headers,
+	})
+}
+
+// 同 setMultipartHeaders，加密或压缩上传的文件缺少元信息时下载后无法还原，
+// 设置失败时删除云端文件，不留下不完整的文件
+func (sess *Session) finishMultipartHeaders(upPath string, extra map[string]string) error {
+	err := sess.setMultipartHeaders(upPath, extra)
+	if err == nil {
+		return nil
+	}
+	_, encrypted := metaValue(extra, EncryptMetaHeader)
+	_, compressed := metaValue(extra, CompressMetaHeader)
+	if !encrypted && !compressed {
+		return err
+	}
+	if e := sess.updriver.Delete(&upyun.DeleteObjectConfig{Path: upPath}); e != nil {
+		return fmt.Errorf("set metadata of %s: %v, delete: %v", upPath, err, e)
+	}
+	return fmt.Errorf("set metadata of %s: %v, uploaded file deleted", upPath, err)
+}
@@ -0,0 +1,73 @@
+package upx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadHeaders(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "headers")
+	os.WriteFile(rulesFile, []byte(`# 静态资源长期缓存
+*.js      Cache-Control: max-age=31536000
+*.html    Cache-Control: no-cache
+/dl/*     Content-Disposition: attachment
+/dl/*.js  cache-control: no-store
+`), 0644)
+
+	rules, err := parseHeaderRules(rulesFile)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 4)
+
+	h := &UploadHeaders{rules: rules, headers: map[string]string{}}
+	assert.Equal(t, map[string]string{"Cache-Control": "max-age=31536000"}, h.For("/static/app.js"))
+	assert.Equal(t, map[string]string{"Cache-Control": "no-cache"}, h.For("/index.html"))
+	assert.Nil(t, h.For("/img/a.png"))
+	// 后面的规则优先
+	assert.Equal(t, map[string]string{
+		"Cache-Control":       "no-store",
+		"Content-Disposition": "attachment",
+	}, h.For("/dl/a.js"))
+
+	// 命令行参数优先于规则文件
+	k, v, err := parseHeader("cache-control:private")
+	assert.NoError(t, err)
+	h.headers[k] = v
+	k, v, err = parseMeta("owner=upx")
+	assert.NoError(t, err)
+	h.headers[k] = v
+	assert.Equal(t, map[string]string{
+		"Cache-Control":      "private",
+		"X-Upyun-Meta-Owner": "upx",
+	}, h.For("/static/app.js"))
+
+	var nilHeaders *UploadHeaders
+	assert.Nil(t, nilHeaders.For("/a.js"))
+
+	_, _, err = parseHeader("Content-Length: 1")
+	assert.Error(t, err)
+	_, _, err = parseHeader("no-colon")
+	assert.Error(t, err)
+	_, _, err = parseMeta("no-equal")
+	assert.Error(t, err)
+}
+
+func TestUploadHeadersDirRule(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "headers")
+	os.WriteFile(rulesFile, []byte(`/dl/     Content-Disposition: attachment
+assets/  Cache-Control: max-age=3600
+`), 0644)
+
+	rules, err := parseHeaderRules(rulesFile)
+	assert.NoError(t, err)
+	h := &UploadHeaders{rules: rules}
+	attachment := map[string]string{"Content-Disposition": "attachment"}
+	assert.Equal(t, attachment, h.For("/dl/a.zip"))
+	assert.Equal(t, attachment, h.For("/dl/2024/05/a.zip"))
+	assert.Nil(t, h.For("/dl"))
+	assert.Nil(t, h.For("/img/dl/a.zip"))
+	assert.Equal(t, map[string]string{"Cache-Control": "max-age=3600"}, h.For("/web/assets/js/app.js"))
+	assert.Nil(t, h.For("/web/assets"))
+}
@@ -15,28 +15,19 @@ var (
 	mu        = &sync.Mutex{}
 )
 
-func NewFileWrappedWriter(localPath string, bar *mpb.Bar, resume bool) (io.WriteCloser, error) {
-	var fd *os.File
-	var err error
-	if resume {
-		fd, err = os.OpenFile(localPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0755)
-	} else {
-		fd, err = os.Create(localPath)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	fileinfo, err := fd.Stat()
-	if err != nil {
-		return nil, err
-	}
+const (
+	// 下载中的临时数据文件和断点续传状态文件的后缀
+	TempFileSuffix  = ".upx-tmp"
+	StateFileSuffix = ".upx-part"
+)
 
-	if bar == nil {
-		return fd, nil
+// 打开下载的临时文件，多个切片会通过 WriteAt 写入到各自的位置
+func NewFileWriterAt(localPath string, resume bool) (*os.File, error) {
+	flag := os.O_CREATE | os.O_RDWR
+	if !resume {
+		flag |= os.O_TRUNC
 	}
-	bar.SetCurrent(fileinfo.Size())
-	return bar.ProxyWriter(fd), nil
+	return os.OpenFile(localPath, flag, 0644)
 }
 
 func NewFileWrappedReader(bar *mpb.Bar, fd io.ReadCloser) io.ReadCloser {
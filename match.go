@@ -1,7 +1,10 @@
 package upx
 
 import (
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/upyun/go-sdk/v3/upyun"
@@ -14,6 +17,13 @@ const (
 	TIME_INTERVAL
 )
 
+const (
+	SIZE_NOT_SET = iota
+	SIZE_LARGER
+	SIZE_SMALLER
+	SIZE_INTERVAL
+)
+
 const (
 	ITEM_NOT_SET = iota
 	DIR
@@ -23,10 +33,21 @@ const (
 type MatchConfig struct {
 	Wildcard string
 
+	// 按顺序生效的 include/exclude 规则
+	Filters []*FilterRule
+	// 相对路径需要完整匹配的正则
+	Regexps []*regexp.Regexp
+
 	TimeType int
 	Before   time.Time
 	After    time.Time
 
+	// 文件大小的闭区间 [MinSize, MaxSize]，目录不参与大小匹配
+	SizeType int
+	MinSize  int64
+	MaxSize  int64
+
+	// 路径字典序区间 [Start, End)，相对路径基于遍历的根目录
 	Start string
 	End   string
 
@@ -40,6 +61,16 @@ func IsMatched(upInfo *upyun.FileInfo, mc *MatchConfig) bool {
 		}
 	}
 
+	for _, re := range mc.Regexps {
+		if !re.MatchString(upInfo.Name) {
+			return false
+		}
+	}
+
+	if len(mc.Filters) > 0 && !matchFilterRules(mc.Filters, upInfo.Name, upInfo.IsDir) {
+		return false
+	}
+
 	switch mc.TimeType {
 	case TIME_BEFORE:
 		if !upInfo.Time.Before(mc.Before) {
@@ -58,6 +89,16 @@ func IsMatched(upInfo *upyun.FileInfo, mc *MatchConfig) bool {
 		}
 	}
 
+	// 目录没有大小，不参与大小匹配
+	if mc.SizeType != SIZE_NOT_SET && !upInfo.IsDir {
+		if (mc.SizeType == SIZE_LARGER || mc.SizeType == SIZE_INTERVAL) && upInfo.Size < mc.MinSize {
+			return false
+		}
+		if (mc.SizeType == SIZE_SMALLER || mc.SizeType == SIZE_INTERVAL) && upInfo.Size > mc.MaxSize {
+			return false
+		}
+	}
+
 	switch mc.ItemType {
 	case DIR:
 		if !upInfo.IsDir {
@@ -71,3 +112,69 @@ func IsMatched(upInfo *upyun.FileInfo, mc *MatchConfig) bool {
 
 	return true
 }
+
+// 是否设置了按名称过滤的条件
+func (mc *MatchConfig) HasNameFilter() bool {
+	return mc.Wildcard != "" || len(mc.Filters) > 0 || len(mc.Regexps) > 0
+}
+
+func (mc *MatchConfig) SetMinSize(size int64) {
+	mc.MinSize = size
+	switch mc.SizeType {
+	case SIZE_NOT_SET:
+		mc.SizeType = SIZE_LARGER
+	case SIZE_SMALLER:
+		mc.SizeType = SIZE_INTERVAL
+	}
+}
+
+func (mc *MatchConfig) SetMaxSize(size int64) {
+	mc.MaxSize = size
+	switch mc.SizeType {
+	case SIZE_NOT_SET:
+		mc.SizeType = SIZE_SMALLER
+	case SIZE_LARGER:
+		mc.SizeType = SIZE_INTERVAL
+	}
+}
+
+// 是否设置了任意过滤条件
+func (mc *MatchConfig) HasFilter() bool {
+	return mc.HasNameFilter() || mc.TimeType != TIME_NOT_SET || mc.SizeType != SIZE_NOT_SET
+}
+
+func (mc *MatchConfig) SetAfter(t time.Time) {
+	mc.After = t
+	switch mc.TimeType {
+	case TIME_NOT_SET:
+		mc.TimeType = TIME_AFTER
+	case TIME_BEFORE:
+		mc.TimeType = TIME_INTERVAL
+	}
+}
+
+func (mc *MatchConfig) SetBefore(t time.Time) {
+	mc.Before = t
+	switch mc.TimeType {
+	case TIME_NOT_SET:
+		mc.TimeType = TIME_BEFORE
+	case TIME_AFTER:
+		mc.TimeType = TIME_INTERVAL
+	}
+}
+
+func (mc *MatchConfig) HasRange() bool {
+	return mc.Start != "" || mc.End != ""
+}
+
+// 将相对的 Start/End 转换为基于 root 的绝对路径
+func (mc *MatchConfig) absRange(root string) (start, end string) {
+	start, end = mc.Start, mc.End
+	if start != "" && !strings.HasPrefix(start, "/") {
+		start = path.Join(root, start)
+	}
+	if end != "" && !strings.HasPrefix(end, "/") {
+		end = path.Join(root, end)
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package upx
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+func TestMatchFilterRules(t *testing.T) {
+	rules := []*FilterRule{
+		{Include: false, Pattern: "tmp/"},
+		{Include: true, Pattern: "*.log"},
+		{Include: false, Pattern: "/build/*.o"},
+		{Include: false, Pattern: "*.bak"},
+	}
+
+	cases := []struct {
+		name  string
+		isDir bool
+		match bool
+	}{
+		{"a.log", false, true},
+		{"tmp", true, false},
+		{"tmp", false, true},
+		{"tmp/a.log", false, false},
+		{"x/tmp/a.txt", false, false},
+		{"a.bak", false, false},
+		{"x/a.bak", false, false},
+		{"build/a.o", false, false},
+		{"x/build/a.o", false, true},
+		{"a.txt", false, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchFilterRules(rules, c.name, c.isDir), c.name)
+	}
+
+	// 规则按顺序生效，先出现的规则优先
+	rules = []*FilterRule{
+		{Include: true, Pattern: "keep.bak"},
+		{Include: false, Pattern: "*.bak"},
+	}
+	assert.True(t, matchFilterRules(rules, "keep.bak", false))
+	assert.False(t, matchFilterRules(rules, "drop.bak", false))
+}
+
+func TestParseFilterFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "filters")
+	err := os.WriteFile(fname, []byte("# comment\n+ *.jpg\n\n- *\ninclude a\nexclude b\n"), 0644)
+	assert.NoError(t, err)
+
+	rules, err := parseFilterFile(fname)
+	assert.NoError(t, err)
+	assert.Equal(t, []*FilterRule{
+		{Include: true, Pattern: "*.jpg"},
+		{Include: false, Pattern: "*"},
+		{Include: true, Pattern: "a"},
+		{Include: false, Pattern: "b"},
+	}, rules)
+
+	err = os.WriteFile(fname, []byte("* *.jpg\n"), 0644)
+	assert.NoError(t, err)
+	_, err = parseFilterFile(fname)
+	assert.Error(t, err)
+}
+
+func TestIsMatchedRegex(t *testing.T) {
+	var regexps []*regexp.Regexp
+	(&regexFlag{regexps: &regexps}).Set(`logs/2024-0[1-3]/.*\.gz`)
+	(&regexFlag{regexps: &regexps, ignoreCase: true}).Set(`.*ACCESS.*`)
+
+	mc := &MatchConfig{Regexps: regexps}
+	assert.True(t, IsMatched(&upyun.FileInfo{Name: "logs/2024-02/access.gz"}, mc))
+	assert.False(t, IsMatched(&upyun.FileInfo{Name: "logs/2024-04/access.gz"}, mc))
+	assert.False(t, IsMatched(&upyun.FileInfo{Name: "logs/2024-02/error.gz"}, mc))
+	assert.False(t, IsMatched(&upyun.FileInfo{Name: "x/logs/2024-02/access.gz"}, mc))
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":    0,
+		"512":  512,
+		"10K":  10 * 1024,
+		"10kb": 10 * 1024,
+		"1.5M": 1536 * 1024,
+		"2G":   2 << 30,
+		"1TiB": 1 << 40,
+	}
+	for v, size := range cases {
+		n, err := parseSize(v)
+		assert.NoError(t, err, v)
+		assert.Equal(t, size, n, v)
+	}
+	for _, v := range []string{"", "K", "1X", "-1"} {
+		_, err := parseSize(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestIsMatchedSize(t *testing.T) {
+	file := func(size int64) *upyun.FileInfo {
+		return &upyun.FileInfo{Name: "a", Size: size}
+	}
+
+	mc := &MatchConfig{}
+	assert.NoError(t, parseSizeFilter("0", mc))
+	assert.True(t, IsMatched(file(0), mc))
+	assert.False(t, IsMatched(file(1), mc))
+	assert.True(t, IsMatched(&upyun.FileInfo{Name: "d", IsDir: true, Size: 4096}, mc))
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseSizeFilter("+1M", mc))
+	assert.False(t, IsMatched(file(1<<20), mc))
+	assert.True(t, IsMatched(file(1<<20+1), mc))
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseSizeFilter("-1K", mc))
+	assert.True(t, IsMatched(file(1023), mc))
+	assert.False(t, IsMatched(file(1024), mc))
+
+	for _, v := range []string{"-0", "-0K", "-0.5", "+-1", "--1", "abc"} {
+		assert.Error(t, parseSizeFilter(v, &MatchConfig{}), v)
+	}
+
+	mc = &MatchConfig{}
+	mc.SetMinSize(100)
+	mc.SetMaxSize(200)
+	assert.Equal(t, SIZE_INTERVAL, mc.SizeType)
+	assert.False(t, IsMatched(file(99), mc))
+	assert.True(t, IsMatched(file(100), mc))
+	assert.True(t, IsMatched(file(200), mc))
+	assert.False(t, IsMatched(file(201), mc))
+}
+
+func TestParseMTime(t *testing.T) {
+	loc, err := parseTimezone("+08:00")
+	assert.NoError(t, err)
+
+	mc := &MatchConfig{}
+	assert.NoError(t, parseMTime("+2h", loc, mc))
+	assert.Equal(t, TIME_BEFORE, mc.TimeType)
+	assert.WithinDuration(t, time.Now().Add(-2*time.Hour), mc.Before, time.Minute)
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("+2", loc, mc))
+	assert.WithinDuration(t, time.Now().Add(-72*time.Hour), mc.Before, time.Minute)
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("-30m", loc, mc))
+	assert.Equal(t, TIME_AFTER, mc.TimeType)
+	assert.WithinDuration(t, time.Now().Add(-30*time.Minute), mc.After, time.Minute)
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("3", loc, mc))
+	assert.Equal(t, TIME_INTERVAL, mc.TimeType)
+	assert.WithinDuration(t, time.Now().Add(-72*time.Hour), mc.Before, time.Minute)
+	assert.WithinDuration(t, time.Now().Add(-96*time.Hour), mc.After, time.Minute)
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("2024-05-01..2024-05-31T12:00", loc, mc))
+	assert.Equal(t, TIME_INTERVAL, mc.TimeType)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, loc).Unix(), mc.After.Unix())
+	assert.Equal(t, time.Date(2024, 5, 31, 12, 0, 0, 0, loc).Unix(), mc.Before.Unix())
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("..2024-05-31", loc, mc))
+	assert.Equal(t, TIME_BEFORE, mc.TimeType)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, loc).Unix(), mc.Before.Unix())
+
+	mc = &MatchConfig{}
+	assert.NoError(t, parseMTime("7d..", loc, mc))
+	assert.Equal(t, TIME_AFTER, mc.TimeType)
+	assert.WithinDuration(t, time.Now().Add(-7*24*time.Hour), mc.After, time.Minute)
+
+	assert.Error(t, parseMTime("..", loc, &MatchConfig{}))
+	assert.Error(t, parseMTime("+3x", loc, &MatchConfig{}))
+}
+
+func TestParseTimeExpr(t *testing.T) {
+	tm, err := parseTimeExpr("2024-05-01T08:30", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), tm)
+
+	tm, err = parseTimeExpr("2024-05-01T08:30:00+08:00", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 30, 0, 0, time.UTC).Unix(), tm.Unix())
+
+	tm, err = parseTimeExpr("@1714521600", time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1714521600), tm.Unix())
+
+	tm, err = parseTimeExpr("1d12h", time.UTC)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-36*time.Hour), tm, time.Minute)
+
+	_, err = parseTimeExpr("yesterday", time.UTC)
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package upx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// 内容嗅探读取的字节数，同 http.DetectContentType
+const sniffLen = 512
+
+const octetStream = "application/octet-stream"
+
+// 内置的扩展名表，补充系统 mime 表中缺失或不准确的类型
+var builtinMimeTypes = map[string]string{
+	".wasm":        "application/wasm",
+	".mjs":         "text/javascript",
+	".js":          "text/javascript",
+	".cjs":         "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".css":         "text/css",
+	".html":        "text/html",
+	".htm":         "text/html",
+	".svg":         "image/svg+xml",
+	".avif":        "image/avif",
+	".webp":        "image/webp",
+	".heic":        "image/heic",
+	".jxl":         "image/jxl",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+	".m3u8":        "application/vnd.apple.mpegurl",
+	".md":          "text/markdown",
+	".apk":         "application/vnd.android.package-archive",
+}
+
+// 解析 mime.types 格式的文件，每行为 "type ext1 ext2 ..."，扩展名可以带或不带 .
+// 返回扩展名到类型的映射，以 # 开头的行为注释
+func parseMimeMap(filename string) (map[string]string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(fd)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		if len(fields) < 2 || !strings.Contains(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: must be \"type ext...\"", filename, n)
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	return types, scanner.Err()
+}
+
+// 根据文件名和内容判断 Content-Type，优先级为：mime 映射文件、内置扩展名表、系统扩展名表、
+// hint (例如 url 响应的 Content-Type)、内容嗅探，无法确定时返回空，由服务端判断
+func detectContentType(name string, head []byte, hint string, overrides map[string]string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != "" {
+		if t, ok := overrides[ext]; ok {
+			return t
+		}
+		if t, ok := builtinMimeTypes[ext]; ok {
+			return t
+		}
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+	}
+	if hint != "" && !strings.HasPrefix(hint, octetStream) {
+		return hint
+	}
+	if len(head) > 0 {
+		if t := http.DetectContentType(head); !strings.HasPrefix(t, octetStream) {
+			return t
+		}
+	}
+	return ""
+}
+
+// 上传到 upPath 的 Content-Type，--header 和规则文件中指定的优先
+func (sess *Session) contentType(upPath string, head []byte, hint string) string {
+	t := sess.headers.For(upPath)["Content-Type"]
+	if t == "" {
+		t = detectContentType(path.Base(upPath), head, hint, sess.mimeTypes)
+	}
+	if t != "" {
+		PrintOnlyVerbose("%s: Content-Type %s", upPath, t)
+	}
+	return t
+}
+
+// 读取文件开头的内容用于嗅探
+func (sess *Session) fileContentType(upPath string, r io.ReaderAt) string {
+	head := make([]byte, sniffLen)
+	n, _ := r.ReadAt(head, 0)
+	return sess.contentType(upPath, head[:n], "")
+}
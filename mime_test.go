@@ -0,0 +1,43 @@
+package upx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectContentType(t *testing.T) {
+	overrides := map[string]string{".log": "text/x-log"}
+	png := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+
+	assert.Equal(t, "application/wasm", detectContentType("app.wasm", nil, "", nil))
+	assert.Equal(t, "text/javascript", detectContentType("mod.MJS", nil, "", nil))
+	assert.Equal(t, "image/avif", detectContentType("a.avif", nil, "", nil))
+	assert.Equal(t, "text/x-log", detectContentType("x.log", []byte("hello"), "", overrides))
+	assert.Equal(t, "image/png", detectContentType("noext", png, "", nil))
+	assert.Equal(t, "image/png", detectContentType("x.unknownext", png, "", nil))
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType("README", []byte("hello world"), "", nil))
+	assert.Equal(t, "image/jpeg", detectContentType("download", png, "image/jpeg", nil))
+	assert.Equal(t, "image/png", detectContentType("download", png, "application/octet-stream", nil))
+	assert.Equal(t, "", detectContentType("blob", []byte{0, 1, 2, 3}, "", nil))
+}
+
+func TestParseMimeMap(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "mime.types")
+	os.WriteFile(fpath, []byte("# comment\napplication/x-ndjson ndjson .JSONL\ntext/plain log conf;\n"), 0644)
+
+	types, err := parseMimeMap(fpath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		".ndjson": "application/x-ndjson",
+		".jsonl":  "application/x-ndjson",
+		".log":    "text/plain",
+		".conf":   "text/plain",
+	}, types)
+
+	os.WriteFile(fpath, []byte("ndjson\n"), 0644)
+	_, err = parseMimeMap(fpath)
+	assert.Error(t, err)
+}
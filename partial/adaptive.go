@@ -0,0 +1,122 @@
+package partial
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	MinChunkSize = 1024 * 1024
+	MaxChunkSize = 1024 * 1024 * 64
+
+	// 目标切片数量，文件越大切片越大
+	targetChunks = 32
+)
+
+// 根据文件大小选择切片大小，按 MB 对齐，限制在 [MinChunkSize, MaxChunkSize] 之间
+func ChunkSizeFor(size int64) int64 {
+	chunkSize := (size/targetChunks + MinChunkSize - 1) / MinChunkSize * MinChunkSize
+	if chunkSize < MinChunkSize {
+		chunkSize = MinChunkSize
+	}
+	if chunkSize > MaxChunkSize {
+		chunkSize = MaxChunkSize
+	}
+	return chunkSize
+}
+
+// 根据观测到的吞吐量调整同时下载的切片数
+// 每完成 limit 个切片统计一次吞吐量，提升明显时增加并发，明显下降或被限流时减少并发
+type adaptiveLimit struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	max    int
+	active int
+	closed bool
+
+	windowStart  time.Time
+	windowBytes  int64
+	windowChunks int
+	lastRate     float64
+}
+
+func newAdaptiveLimit(max int) *adaptiveLimit {
+	l := &adaptiveLimit{
+		limit:       (max + 1) / 2,
+		max:         max,
+		windowStart: time.Now(),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// 等待可用的并发数，关闭后返回 false
+func (l *adaptiveLimit) Acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.closed && l.active >= l.limit {
+		l.cond.Wait()
+	}
+	if l.closed {
+		return false
+	}
+	l.active++
+	return true
+}
+
+func (l *adaptiveLimit) Release(n int64, throttled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	defer l.cond.Broadcast()
+
+	if throttled {
+		if l.limit > 1 {
+			l.limit /= 2
+		}
+		l.resetWindow(0)
+		return
+	}
+
+	l.windowBytes += n
+	l.windowChunks++
+	if l.windowChunks < l.limit {
+		return
+	}
+	elapsed := time.Since(l.windowStart).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(l.windowBytes) / elapsed
+	switch {
+	case rate > l.lastRate*1.1 && l.limit < l.max:
+		l.limit++
+	case rate < l.lastRate*0.8 && l.limit > 1:
+		l.limit--
+	}
+	l.resetWindow(rate)
+}
+
+// 释放并发但不参与吞吐量统计，用于失败的切片和等待过限速的切片，
+// 它们的速度反映的不是网络状况，计入后会错误地降低并发
+func (l *adaptiveLimit) Skip() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *adaptiveLimit) resetWindow(rate float64) {
+	l.windowStart = time.Now()
+	l.windowBytes = 0
+	l.windowChunks = 0
+	l.lastRate = rate
+}
+
+func (l *adaptiveLimit) Close() {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
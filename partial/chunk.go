@@ -1,9 +1,31 @@
 package partial
 
 import (
-	"sync/atomic"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
 )
 
+var errChunkClosed = errors.New("chunk writer closed")
+
+// 切片多次重试仍然失败，记录失败的字节范围
+type ChunkError struct {
+	Index    int64
+	Start    int64
+	End      int64
+	Attempts int
+	Err      error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d bytes %d-%d failed after %d attempts: %v", e.Index, e.Start, e.End, e.Attempts, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
 type Chunk struct {
 	// 切片的顺序
 	index int64
@@ -13,12 +35,6 @@ type Chunk struct {
 
 	// 切片内容在源文件的结束地址
 	end int64
-
-	// 切片任务的下载错误
-	err error
-
-	// 下载完的切片的具体内容
-	buffer []byte
 }
 
 func NewChunk(index, start, end int64) *Chunk {
@@ -30,68 +46,69 @@ func NewChunk(index, start, end int64) *Chunk {
 	return chunk
 }
 
-func (p *Chunk) SetData(bytes []byte) {
-	p.buffer = bytes
+func (p *Chunk) Size() int64 {
+	return p.end - p.start
 }
 
-func (p *Chunk) SetError(err error) {
-	p.err = err
-}
+// 将切片数据直接写入到文件的对应位置
+// 重试时会从切片开头重新写入，已经上报过的进度不会重复上报
+// 关闭之后的写入都会失败，超时被放弃的请求不会再写入文件
+type chunkWriter struct {
+	mu     sync.Mutex
+	closed bool
 
-func (p *Chunk) Error() error {
-	return p.err
-}
+	writer io.WriterAt
+	chunk  *Chunk
 
-func (p *Chunk) Data() []byte {
-	return p.buffer
-}
+	// 本次写入的位置
+	offset int64
 
-// 切片乱序写入后，将切片顺序读取
-type ChunksSorter struct {
-	// 已经读取的切片数量
-	readCount int64
-
-	// 切片的所有总数
-	chunkCount int64
-
-	// 线程数，用于阻塞写入
-	works int64
-
-	// 存储切片的缓存区
-	chunks []chan *Chunk
+	// 已经上报进度的位置
+	reported *int64
+	progress func(n int64)
 }
 
-func NewChunksSorter(chunkCount int64, works int) *ChunksSorter {
-	chunks := make([]chan *Chunk, works)
-	for i := 0; i < len(chunks); i++ {
-		chunks[i] = make(chan *Chunk)
-	}
-
-	return &ChunksSorter{
-		chunkCount: chunkCount,
-		works:      int64(works),
-		chunks:     chunks,
+func newChunkWriter(writer io.WriterAt, chunk *Chunk, reported *int64, progress func(n int64)) *chunkWriter {
+	return &chunkWriter{
+		writer:   writer,
+		chunk:    chunk,
+		offset:   chunk.start,
+		reported: reported,
+		progress: progress,
 	}
 }
 
-// 将数据写入到缓存区，如果该缓存已满，则会被阻塞
-func (p *ChunksSorter) Write(chunk *Chunk) {
-	p.chunks[chunk.index%p.works] <- chunk
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errChunkClosed
+	}
+	if w.offset+int64(len(p)) > w.chunk.end {
+		return 0, errors.New("chunk data exceeds range")
+	}
+	n, err := w.writer.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	if w.offset > *w.reported {
+		if w.progress != nil {
+			w.progress(w.offset - *w.reported)
+		}
+		*w.reported = w.offset
+	}
+	return n, err
 }
 
-// 关闭 workId 下的通道
-func (p *ChunksSorter) Close(workId int) {
-	if (len(p.chunks) - 1) >= workId {
-		close(p.chunks[workId])
-	}
+// 等待正在进行的写入完成后关闭
+func (w *chunkWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
 }
 
-// 顺序读取切片，如果下一个切片没有下载完，则会被阻塞
-func (p *ChunksSorter) Read() *Chunk {
-	if p.chunkCount == 0 {
-		return nil
-	}
-	i := atomic.AddInt64(&p.readCount, 1)
-	chunk := <-p.chunks[(i-1)%p.works]
-	return chunk
+// 本次写入的数据量
+func (w *chunkWriter) Written() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.offset - w.chunk.start
 }
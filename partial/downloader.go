@@ -1,145 +1,400 @@
 package partial
 
 import (
+	"bufio"
 	"context"
-	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"log"
-	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const DefaultChunkSize = 1024 * 1024 * 10
+const (
+	DefaultChunkSize = 1024 * 1024 * 10
 
-type ChunkDownFunc func(start, end int64) ([]byte, error)
+	// 所有下载线程写缓冲区的总大小
+	DefaultMemoryBudget = 1024 * 1024 * 16
+	minBufferSize       = 32 * 1024
 
-type MultiPartialDownloader struct {
+	// 切片超时时间为 chunkTimeoutBase 加上按最低速度下载切片所需的时间
+	chunkTimeoutBase = 30 * time.Second
+	minChunkRate     = 64 * 1024
+)
+
+// 下载 [start, end] 范围内的数据并写入到 w，ctx 超时或取消后写入 w 会失败
+type ChunkDownFunc func(ctx context.Context, start, end int64, w io.Writer) error
+
+type Option func(*MultiPartialDownloader)
+
+// 限制下载缓冲区占用的总内存
+func WithMemoryBudget(budget int64) Option {
+	return func(p *MultiPartialDownloader) {
+		if budget > 0 {
+			p.memoryBudget = budget
+		}
+	}
+}
+
+// 每写入 n 个新字节时回调，用于更新进度条
+func WithProgress(fn func(n int64)) Option {
+	return func(p *MultiPartialDownloader) {
+		p.progress = fn
+	}
+}
+
+// 记录已完成的切片，下载时跳过这些切片，中断后可以从状态文件继续下载
+// 切片大小以状态文件中记录的为准
+func WithState(state *State) Option {
+	return func(p *MultiPartialDownloader) {
+		p.state = state
+		if state != nil {
+			p.chunkSize = state.ChunkSize
+		}
+	}
+}
+
+// 按顺序计算文件的哈希，切片完成后从 r 中读回数据写入 h
+// 不需要等待全部下载完成，也不需要额外保存数据
+func WithHash(h hash.Hash, r io.ReaderAt) Option {
+	return func(p *MultiPartialDownloader) {
+		p.hash = h
+		p.reader = r
+	}
+}
+
+// 重试时的退避策略，被限流的错误会等待更久并降低并发数
+func WithBackoff(base, max time.Duration) Option {
+	return func(p *MultiPartialDownloader) {
+		p.backoffBase = base
+		p.backoffMax = max
+	}
+}
+
+// 判断错误是否为服务端限流，例如 429 Too Many Requests
+func WithThrottleCheck(fn func(error) bool) Option {
+	return func(p *MultiPartialDownloader) {
+		p.isThrottled = fn
+	}
+}
+
+// 固定每个切片的超时时间，默认根据切片大小计算
+func WithChunkTimeout(timeout time.Duration) Option {
+	return func(p *MultiPartialDownloader) {
+		p.chunkTimeout = timeout
+	}
+}
+
+// 写入前调用 wait 申请 n 个字节的令牌，用于限速，等待令牌的时间不计入切片超时
+func WithRateLimit(wait func(n int)) Option {
+	return func(p *MultiPartialDownloader) {
+		p.wait = wait
+	}
+}
 
-	// 文件路径
-	filePath string
+type MultiPartialDownloader struct {
 
 	// 最终文件大小
 	finalSize int64
 
-	// 本地文件大小
-	localSize int64
-
 	//分片大小
 	chunkSize int64
 
-	writer   io.Writer
+	// 写缓冲区总大小
+	memoryBudget int64
+
+	writer   io.WriterAt
 	works    int
 	downFunc ChunkDownFunc
+	progress func(n int64)
+	state    *State
+
+	hash   hash.Hash
+	reader io.ReaderAt
+
+	retryPolicy
+	chunkTimeout time.Duration
+	wait         func(n int)
 }
 
-func NewMultiPartialDownloader(filePath string, finalSize, chunkSize int64, writer io.Writer, works int, fn ChunkDownFunc) *MultiPartialDownloader {
-	return &MultiPartialDownloader{
-		filePath:  filePath,
-		finalSize: finalSize,
-		works:     works,
-		writer:    writer,
-		chunkSize: chunkSize,
-		downFunc:  fn,
+func NewMultiPartialDownloader(finalSize, chunkSize int64, writer io.WriterAt, works int, fn ChunkDownFunc, opts ...Option) *MultiPartialDownloader {
+	p := &MultiPartialDownloader{
+		finalSize:    finalSize,
+		works:        works,
+		writer:       writer,
+		chunkSize:    chunkSize,
+		memoryBudget: DefaultMemoryBudget,
+		downFunc:     fn,
+		retryPolicy:  defaultRetryPolicy(),
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *MultiPartialDownloader) Download() error {
-	fileinfo, err := os.Stat(p.filePath)
+	// 预先分配文件大小，切片下载完成后直接写入对应位置
+	if t, ok := p.writer.(interface{ Truncate(int64) error }); ok {
+		if err := t.Truncate(p.finalSize); err != nil {
+			return err
+		}
+	}
 
-	// 如果异常
-	// - 文件不存在异常: localSize 默认值 0
-	// - 不是文件不存在异常: 报错
-	if err != nil && !os.IsNotExist(err) {
-		return err
+	// 计算需要下载的块，跳过状态文件中已经完成的块
+	// 每个切片完成时关闭对应的 channel，用于按顺序计算哈希
+	var chunks []int64
+	var completed []chan struct{}
+	for j := int64(0); j*p.chunkSize < p.finalSize; j++ {
+		done := make(chan struct{})
+		if p.state != nil && p.state.IsDone(j) {
+			close(done)
+		} else {
+			chunks = append(chunks, j)
+		}
+		completed = append(completed, done)
 	}
-	if err == nil {
-		p.localSize = fileinfo.Size()
+	chunkCount := int64(len(chunks))
+
+	var (
+		wg       sync.WaitGroup
+		next     int64 = -1
+		errOnce  sync.Once
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
 	}
 
-	// 计算需要下载的块数
-	needDownSize := p.finalSize - p.localSize
-	chunkCount := needDownSize / p.chunkSize
-	if needDownSize%p.chunkSize != 0 {
-		chunkCount++
+	if p.hash != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.hashChunks(ctx, completed); err != nil {
+				setErr(err)
+			}
+		}()
 	}
 
-	chunksSorter := NewChunksSorter(
-		chunkCount,
-		p.works,
-	)
+	works := p.works
+	if int64(works) > chunkCount {
+		works = int(chunkCount)
+	}
+	if works < 1 {
+		works = 1
+	}
+	bufSize := int(p.memoryBudget / int64(works))
+	if bufSize < minBufferSize {
+		bufSize = minBufferSize
+	}
 
-	// 下载切片任务
-	var wg sync.WaitGroup
-	ctx, cancel := context.WithCancel(context.Background())
-	defer func() {
-		// 取消切片下载任务，并等待
-		cancel()
-		wg.Wait()
+	// works 是并发数的上限，实际并发数根据吞吐量调整
+	limit := newAdaptiveLimit(works)
+	go func() {
+		<-ctx.Done()
+		limit.Close()
 	}()
 
-	for i := 0; i < p.works; i++ {
+	for i := 0; i < works; i++ {
 		wg.Add(1)
-		go func(ctx context.Context, workId int) {
-			defer func() {
-				// 关闭 workId 下的接收通道
-				chunksSorter.Close(workId)
-				wg.Done()
-			}()
-
-			// 每个 work 取自己倍数的 chunk
-			for j := workId; j < int(chunkCount); j += p.works {
-				select {
-				case <-ctx.Done():
+		go func() {
+			defer wg.Done()
+			buffer := bufio.NewWriterSize(nil, bufSize)
+
+			// 空闲的 work 领取下一个切片，慢的切片不会阻塞其他 work
+			for {
+				if !limit.Acquire() {
+					return
+				}
+				k := atomic.AddInt64(&next, 1)
+				if k >= chunkCount || ctx.Err() != nil {
+					limit.Skip()
 					return
-				default:
-					var (
-						err    error
-						buffer []byte
-					)
-					start := p.localSize + int64(j)*p.chunkSize
-					end := p.localSize + int64(j+1)*p.chunkSize
-					if end > p.finalSize {
-						end = p.finalSize
+				}
+
+				j := chunks[k]
+				start := j * p.chunkSize
+				end := start + p.chunkSize
+				if end > p.finalSize {
+					end = p.finalSize
+				}
+				chunk := NewChunk(j, start, end)
+
+				var reported = chunk.start
+				var err error
+				var attempts int
+				var limited bool
+				held := true
+				for {
+					attempts++
+					abandoned, waited, e := p.fetch(ctx, chunk, buffer, &reported)
+					limited = limited || waited > 0
+					if abandoned {
+						// 超时的请求仍在使用缓冲区，换一个新的
+						buffer = bufio.NewWriterSize(nil, bufSize)
+					}
+					err = e
+					if err == nil || attempts >= p.maxAttempts || ctx.Err() != nil {
+						break
 					}
-					chunk := NewChunk(int64(j), start, end)
-
-					// 重试三次
-					for t := 0; t < 3; t++ {
-						// ? 由于长度是从1开始，而数据是从0地址开始
-						// ? 计算字节时容量会多出开头的一位，所以末尾需要减少一位
-						buffer, err = p.downFunc(chunk.start, chunk.end-1)
-						if err == nil {
+					// 被限流时先让出并发，等待之后重新申请
+					throttled := p.throttled(err)
+					if throttled {
+						limit.Release(0, true)
+						held = false
+					}
+					if !sleepContext(ctx, p.backoff(attempts, throttled)) {
+						break
+					}
+					if !held {
+						if !limit.Acquire() {
 							break
 						}
+						held = true
+					}
+				}
+				if held {
+					if err == nil && !limited {
+						limit.Release(chunk.Size(), false)
+					} else {
+						limit.Skip()
 					}
-					chunk.SetData(buffer)
-					chunk.SetError(err)
-					chunksSorter.Write(chunk)
+				}
+				if err == nil && p.state != nil {
+					err = p.markDone(chunk)
+				}
 
-					if err != nil {
-						log.Printf("part %d, error: %s", chunk.index, err)
+				if err != nil {
+					// 其他切片已经失败，不再重复报错
+					if ctx.Err() != nil {
 						return
 					}
+					err = &ChunkError{Index: chunk.index, Start: chunk.start, End: chunk.end - 1, Attempts: attempts, Err: err}
+					log.Printf("part %d, error: %s", chunk.index, err)
+					setErr(err)
+					return
 				}
+				close(completed[j])
 			}
-		}(ctx, i)
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// 下载一个切片，超时后不再等待请求返回，此时 abandoned 为 true，
+// 请求之后的写入会被 chunkWriter 拒绝，不会覆盖重试写入的数据
+// 限速时等待令牌的时间顺延超时时间，低速率下的大切片不会因为限速超时，waited 为等待令牌的时间
+func (p *MultiPartialDownloader) fetch(ctx context.Context, chunk *Chunk, buffer *bufio.Writer, reported *int64) (abandoned bool, waited time.Duration, err error) {
+	timeout := p.chunkTimeout
+	if timeout <= 0 {
+		timeout = chunkTimeoutBase + time.Duration(chunk.Size()/minChunkRate)*time.Second
 	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// 将分片顺序写入到文件
+	w := newChunkWriter(p.writer, chunk, reported, p.progress)
+	lw := &limitedWriter{w: w, wait: p.wait}
+	done := make(chan error, 1)
+	go func() {
+		var out io.Writer = w
+		if p.wait != nil {
+			out = lw
+		}
+		buffer.Reset(out)
+		// ? 由于长度是从1开始，而数据是从0地址开始
+		// ? 计算字节时容量会多出开头的一位，所以末尾需要减少一位
+		err := p.downFunc(ctx, chunk.start, chunk.end-1, buffer)
+		if err == nil {
+			err = buffer.Flush()
+		}
+		done <- err
+	}()
+
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	for {
-		chunk := chunksSorter.Read()
-		if chunk == nil {
-			break
+		select {
+		case err = <-done:
+			w.Close()
+			if err == nil && w.Written() != chunk.Size() {
+				err = fmt.Errorf("chunk size mismatch, want %d got %d", chunk.Size(), w.Written())
+			}
+			return false, lw.Waited(), err
+		case <-parent.Done():
+			w.Close()
+			return true, lw.Waited(), parent.Err()
+		case <-timer.C:
+			if remaining := time.Until(deadline.Add(lw.Waited())); remaining > 0 {
+				timer.Reset(remaining)
+				continue
+			}
+			cancel()
+			w.Close()
+			return true, lw.Waited(), context.DeadlineExceeded
+		}
+	}
+}
+
+// 记录等待令牌的总时间，包括正在进行的等待
+type limitedWriter struct {
+	w    io.Writer
+	wait func(n int)
+
+	waited  int64
+	waiting int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	atomic.StoreInt64(&w.waiting, start.UnixNano())
+	w.wait(len(p))
+	atomic.AddInt64(&w.waited, int64(time.Since(start)))
+	atomic.StoreInt64(&w.waiting, 0)
+	return w.w.Write(p)
+}
+
+func (w *limitedWriter) Waited() time.Duration {
+	waited := atomic.LoadInt64(&w.waited)
+	if since := atomic.LoadInt64(&w.waiting); since > 0 {
+		waited += time.Now().UnixNano() - since
+	}
+	return time.Duration(waited)
+}
+
+// 数据落盘之后再更新状态文件，避免崩溃后状态文件记录了未写入的切片
+func (p *MultiPartialDownloader) markDone(chunk *Chunk) error {
+	if s, ok := p.writer.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
 		}
-		if chunk.Error() != nil {
-			return chunk.Error()
+	}
+	return p.state.SetDone(chunk.index)
+}
+
+func (p *MultiPartialDownloader) hashChunks(ctx context.Context, completed []chan struct{}) error {
+	for j, done := range completed {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil
 		}
-		if len(chunk.Data()) == 0 {
-			return errors.New("chunk buffer download but size is 0")
+		start := int64(j) * p.chunkSize
+		end := start + p.chunkSize
+		if end > p.finalSize {
+			end = p.finalSize
 		}
-		_, err := p.writer.Write(chunk.Data())
-		if err != nil {
-			log.Printf("part %d, error: %s", chunk.index, err)
+		if _, err := io.Copy(p.hash, io.NewSectionReader(p.reader, start, end-start)); err != nil {
 			return err
 		}
 	}
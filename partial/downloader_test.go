@@ -2,23 +2,57 @@ package partial
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"errors"
+	"io"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+type memWriterAt struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if need := off + int64(len(p)); need > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, need-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memWriterAt) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 func TestDownload(t *testing.T) {
-	var buffer bytes.Buffer
+	var buffer memWriterAt
 
 	filedata := []byte(strings.Repeat("hello world", 1024*100))
 	download := NewMultiPartialDownloader(
-		"myTestfile",
 		int64(len(filedata)),
 		1024,
 		&buffer,
 		3,
-		func(start, end int64) ([]byte, error) {
-			return filedata[start : end+1], nil
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			_, err := w.Write(filedata[start : end+1])
+			return err
 		},
 	)
 
@@ -26,7 +60,282 @@ func TestDownload(t *testing.T) {
 	if err != nil {
 		t.Fatal(err.Error())
 	}
-	if md5.Sum(buffer.Bytes()) != md5.Sum(filedata) {
+	if md5.Sum(buffer.data) != md5.Sum(filedata) {
+		t.Fatal("download file has diff MD5")
+	}
+}
+
+func TestDownloadRetryProgress(t *testing.T) {
+	var buffer memWriterAt
+	var progress int64
+	var failed sync.Map
+
+	filedata := []byte(strings.Repeat("0123456789", 1024*10))
+	download := NewMultiPartialDownloader(
+		int64(len(filedata)),
+		4096,
+		&buffer,
+		4,
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			// 每个切片第一次只写入一半数据就失败
+			if _, loaded := failed.LoadOrStore(start, true); !loaded {
+				w.Write(filedata[start : start+(end-start)/2])
+				return errors.New("connection reset")
+			}
+			_, err := w.Write(filedata[start : end+1])
+			return err
+		},
+		WithMemoryBudget(1024),
+		WithBackoff(time.Millisecond, 10*time.Millisecond),
+		WithProgress(func(n int64) {
+			atomic.AddInt64(&progress, n)
+		}),
+	)
+
+	if err := download.Download(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if md5.Sum(buffer.data) != md5.Sum(filedata) {
+		t.Fatal("download file has diff MD5")
+	}
+	if progress != int64(len(filedata)) {
+		t.Fatalf("progress %d, want %d", progress, len(filedata))
+	}
+}
+
+func TestDownloadResumeState(t *testing.T) {
+	var buffer memWriterAt
+	var calls int64
+
+	filedata := []byte(strings.Repeat("0123456789", 1024*10))
+	statePath := filepath.Join(t.TempDir(), "myTestfile.upx-part")
+	state := NewState(statePath, int64(len(filedata)), "", 0, 4096)
+
+	down := func(_ context.Context, start, end int64, w io.Writer) error {
+		atomic.AddInt64(&calls, 1)
+		// 模拟下载到一半时中断
+		if start >= 4096*10 {
+			return errors.New("connection reset")
+		}
+		_, err := w.Write(filedata[start : end+1])
+		return err
+	}
+	if err := NewMultiPartialDownloader(int64(len(filedata)), 4096, &buffer, 1, down, WithState(state), WithBackoff(time.Millisecond, time.Millisecond)).Download(); err == nil {
+		t.Fatal("expected download error")
+	}
+
+	loaded, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !loaded.Same(int64(len(filedata)), "", 0) {
+		t.Fatal("state should match the remote file")
+	}
+	if loaded.Same(int64(len(filedata)), "", 1) {
+		t.Fatal("state should not match a modified remote file")
+	}
+	if loaded.Completed() != 4096*10 {
+		t.Fatalf("completed %d, want %d", loaded.Completed(), 4096*10)
+	}
+	if loaded.CompletedEnd() != 4096*10 {
+		t.Fatalf("completed end %d, want %d", loaded.CompletedEnd(), 4096*10)
+	}
+
+	calls = 0
+	down = func(ctx context.Context, start, end int64, w io.Writer) error {
+		atomic.AddInt64(&calls, 1)
+		if start < 4096*10 {
+			return errors.New("completed chunk downloaded again")
+		}
+		_, err := w.Write(filedata[start : end+1])
+		return err
+	}
+	if err := NewMultiPartialDownloader(int64(len(filedata)), 4096, &buffer, 2, down, WithState(loaded)).Download(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if md5.Sum(buffer.data) != md5.Sum(filedata) {
 		t.Fatal("download file has diff MD5")
 	}
+	if want := loaded.ChunkCount() - 10; calls != want {
+		t.Fatalf("downloaded %d chunks, want %d", calls, want)
+	}
+}
+
+func TestDownloadHash(t *testing.T) {
+	var buffer memWriterAt
+
+	filedata := []byte(strings.Repeat("hello world", 1024*100))
+	h := md5.New()
+	download := NewMultiPartialDownloader(
+		int64(len(filedata)),
+		1000,
+		&buffer,
+		4,
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			_, err := w.Write(filedata[start : end+1])
+			return err
+		},
+		WithHash(h, &buffer),
+	)
+
+	if err := download.Download(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if sum := md5.Sum(filedata); !bytes.Equal(h.Sum(nil), sum[:]) {
+		t.Fatal("streaming hash has diff MD5")
+	}
+}
+
+func TestDownloadChunkError(t *testing.T) {
+	var buffer memWriterAt
+	var attempts int64
+	var throttled int64
+
+	errThrottled := errors.New("429 too many requests")
+	filedata := []byte(strings.Repeat("0123456789", 1024))
+	download := NewMultiPartialDownloader(
+		int64(len(filedata)),
+		1024,
+		&buffer,
+		3,
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			if start == 2048 {
+				atomic.AddInt64(&attempts, 1)
+				return errThrottled
+			}
+			_, err := w.Write(filedata[start : end+1])
+			return err
+		},
+		WithBackoff(time.Millisecond, 5*time.Millisecond),
+		WithThrottleCheck(func(err error) bool {
+			atomic.AddInt64(&throttled, 1)
+			return errors.Is(err, errThrottled)
+		}),
+	)
+
+	err := download.Download()
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("expected ChunkError, got %v", err)
+	}
+	if chunkErr.Start != 2048 || chunkErr.End != 3071 || chunkErr.Attempts != DefaultMaxAttempts {
+		t.Fatalf("unexpected chunk error: %v", chunkErr)
+	}
+	if !errors.Is(err, errThrottled) {
+		t.Fatal("chunk error should wrap the last error")
+	}
+	if attempts != DefaultMaxAttempts || throttled == 0 {
+		t.Fatalf("attempts %d throttled %d", attempts, throttled)
+	}
+}
+
+func TestDownloadChunkTimeout(t *testing.T) {
+	var buffer memWriterAt
+	var stalled sync.Map
+
+	filedata := []byte(strings.Repeat("0123456789", 1024))
+	download := NewMultiPartialDownloader(
+		int64(len(filedata)),
+		1024,
+		&buffer,
+		2,
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			// 第一次请求写入一半后卡住，超时后才继续写入剩余的错误数据
+			if _, loaded := stalled.LoadOrStore(start, true); !loaded {
+				w.Write(filedata[start : start+10])
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				w.Write(bytes.Repeat([]byte("x"), int(end-start+1-10)))
+				return nil
+			}
+			_, err := w.Write(filedata[start : end+1])
+			return err
+		},
+		WithMemoryBudget(1),
+		WithChunkTimeout(20*time.Millisecond),
+		WithBackoff(time.Millisecond, time.Millisecond),
+	)
+
+	if err := download.Download(); err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(50 * time.Millisecond)
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if md5.Sum(buffer.data) != md5.Sum(filedata) {
+		t.Fatal("abandoned request overwrote downloaded data")
+	}
+}
+
+func TestChunkSizeFor(t *testing.T) {
+	cases := []struct {
+		size, want int64
+	}{
+		{0, MinChunkSize},
+		{5 * 1024 * 1024, MinChunkSize},
+		{100 * 1024 * 1024, 4 * 1024 * 1024},
+		{100 * 1024 * 1024 * 1024, MaxChunkSize},
+	}
+	for _, c := range cases {
+		if got := ChunkSizeFor(c.size); got != c.want {
+			t.Errorf("ChunkSizeFor(%d) = %d, want %d", c.size, got, c.want)
+		}
+	}
+}
+
+func TestDownloadRateLimitedChunk(t *testing.T) {
+	var buffer memWriterAt
+	filedata := []byte(strings.Repeat("0123456789", 8*1024))
+	download := NewMultiPartialDownloader(
+		int64(len(filedata)),
+		int64(len(filedata)/2),
+		&buffer,
+		2,
+		func(ctx context.Context, start, end int64, w io.Writer) error {
+			for i := start; i <= end; i += 4096 {
+				if _, err := w.Write(filedata[i:min(i+4096, end+1)]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		WithMemoryBudget(1),
+		WithChunkTimeout(30*time.Millisecond),
+		WithBackoff(time.Millisecond, time.Millisecond),
+		// 每个切片等待令牌的时间远超过超时时间
+		WithRateLimit(func(n int) {
+			time.Sleep(time.Duration(n) * time.Millisecond / 256)
+		}),
+	)
+
+	if err := download.Download(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if md5.Sum(buffer.data) != md5.Sum(filedata) {
+		t.Fatal("downloaded data differs")
+	}
+}
+
+func TestAdaptiveLimitSkip(t *testing.T) {
+	l := newAdaptiveLimit(4)
+	l.lastRate = 1 << 30
+	// 失败或者限速的切片不参与吞吐量统计，不会降低并发
+	for i := 0; i < 10; i++ {
+		if !l.Acquire() {
+			t.Fatal("acquire failed")
+		}
+		l.Skip()
+	}
+	if l.limit != 2 || l.active != 0 {
+		t.Fatalf("limit %d active %d", l.limit, l.active)
+	}
+
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		l.Acquire()
+		l.Release(1, false)
+	}
+	if l.limit != 1 {
+		t.Fatalf("slow chunks should lower the limit, got %d", l.limit)
+	}
 }
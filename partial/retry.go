@@ -0,0 +1,60 @@
+package partial
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// 每个切片最多尝试的次数，以及重试之间指数退避的初始和最大等待时间
+	DefaultMaxAttempts = 5
+	DefaultBackoffBase = 500 * time.Millisecond
+	DefaultBackoffMax  = 30 * time.Second
+)
+
+// 上传和下载共用的重试策略
+type retryPolicy struct {
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+
+	// 判断错误是否为服务端限流
+	isThrottled func(error) bool
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxAttempts: DefaultMaxAttempts,
+		backoffBase: DefaultBackoffBase,
+		backoffMax:  DefaultBackoffMax,
+	}
+}
+
+func (r *retryPolicy) throttled(err error) bool {
+	return err != nil && r.isThrottled != nil && r.isThrottled(err)
+}
+
+// 全抖动的指数退避，被限流时等待时间更长
+func (r *retryPolicy) backoff(attempt int, throttled bool) time.Duration {
+	d := r.backoffBase << uint(attempt-1)
+	if throttled {
+		d *= 4
+	}
+	if d > r.backoffMax || d <= 0 {
+		d = r.backoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 等待 d，ctx 取消时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package partial
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// 断点续传的状态文件，记录远程文件的信息以及已经下载完成的切片
+// 远程文件发生变化时，状态文件失效，需要重新下载
+type State struct {
+	Size         int64  `json:"size"`
+	MD5          string `json:"md5"`
+	LastModified int64  `json:"last_modified"`
+	ChunkSize    int64  `json:"chunk_size"`
+
+	// 已完成切片的位图
+	Bitmap []byte `json:"bitmap"`
+
+	path string
+	mu   sync.Mutex
+}
+
+func NewState(path string, size int64, md5 string, lastModified, chunkSize int64) *State {
+	chunkCount := (size + chunkSize - 1) / chunkSize
+	return &State{
+		Size:         size,
+		MD5:          md5,
+		LastModified: lastModified,
+		ChunkSize:    chunkSize,
+		Bitmap:       make([]byte, (chunkCount+7)/8),
+		path:         path,
+	}
+}
+
+func LoadState(path string) (*State, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{path: path}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// 判断状态文件是否对应同一个远程文件
+func (s *State) Same(size int64, md5 string, lastModified int64) bool {
+	if s.ChunkSize <= 0 || int64(len(s.Bitmap)) != (s.ChunkCount()+7)/8 {
+		return false
+	}
+	return s.Size == size && s.MD5 == md5 && s.LastModified == lastModified
+}
+
+func (s *State) ChunkCount() int64 {
+	if s.ChunkSize <= 0 {
+		return 0
+	}
+	return (s.Size + s.ChunkSize - 1) / s.ChunkSize
+}
+
+func (s *State) IsDone(index int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Bitmap[index/8]&(1<<(index%8)) != 0
+}
+
+// 标记切片完成并保存状态文件
+func (s *State) SetDone(index int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Bitmap[index/8] |= 1 << (index % 8)
+	return s.save()
+}
+
+// 已经下载完成的字节数
+func (s *State) Completed() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for i := int64(0); i < s.ChunkCount(); i++ {
+		if s.Bitmap[i/8]&(1<<(i%8)) != 0 {
+			end := (i + 1) * s.ChunkSize
+			if end > s.Size {
+				end = s.Size
+			}
+			n += end - i*s.ChunkSize
+		}
+	}
+	return n
+}
+
+// 最后一个已完成切片的结束位置，临时文件至少要有这么长，否则已完成的切片不可信
+func (s *State) CompletedEnd() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := s.ChunkCount() - 1; i >= 0; i-- {
+		if s.Bitmap[i/8]&(1<<(i%8)) != 0 {
+			return min((i+1)*s.ChunkSize, s.Size)
+		}
+	}
+	return 0
+}
+
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// 先写临时文件再重命名，避免进程中断时状态文件损坏
+func (s *State) save() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *State) Remove() error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
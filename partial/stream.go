@@ -0,0 +1,111 @@
+package partial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// 云存储分块上传最多 10000 个分片，分片大小在初始化时确定，数据流最多上传 MaxStreamParts*partSize 字节
+const MaxStreamParts = 10000
+
+var ErrTooManyParts = errors.New("too many parts")
+
+// 上传长度未知的数据流，例如标准输入或者没有 Content-Length 的 http 响应
+// 数据按分片大小读入内存后并发上传，最多同时占用 works+1 个分片的内存
+func NewStreamUploader(partSize int64, works int, fn PartUpFunc, opts ...UploadOption) *MultiPartialUploader {
+	return NewMultiPartialUploader(nil, -1, partSize, works, fn, opts...)
+}
+
+// 按顺序读取 r 直到 EOF，返回读取的总字节数
+func (p *MultiPartialUploader) UploadStream(r io.Reader) (int64, error) {
+	works := p.works
+	if works < 1 {
+		works = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	type streamPart struct {
+		chunk *Chunk
+		data  []byte
+	}
+	parts := make(chan *streamPart)
+	buffers := make(chan []byte, works+1)
+	for i := 0; i < works+1; i++ {
+		buffers <- nil
+	}
+
+	for i := 0; i < works; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				if ctx.Err() == nil {
+					open := func() io.Reader {
+						return bytes.NewReader(part.data)
+					}
+					if err := p.uploadPart(ctx, part.chunk, open); err != nil && ctx.Err() == nil {
+						log.Printf("part %d, error: %s", part.chunk.index, err)
+						setErr(err)
+					}
+				}
+				buffers <- part.data[:cap(part.data)]
+			}
+		}()
+	}
+
+	var total int64
+	for partID := int64(0); ; partID++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if buf == nil {
+			buf = make([]byte, p.partSize)
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if n > 0 && partID >= MaxStreamParts {
+			setErr(fmt.Errorf("%w: stream exceeds %d parts of %d bytes", ErrTooManyParts, MaxStreamParts, p.partSize))
+			break
+		}
+		if n > 0 {
+			parts <- &streamPart{
+				chunk: NewChunk(partID, total, total+int64(n)),
+				data:  buf[:n],
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			setErr(err)
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+	return total, firstErr
+}
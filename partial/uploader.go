@@ -0,0 +1,191 @@
+package partial
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 上传编号为 partID 的分片，r 中为分片的 size 字节数据
+type PartUpFunc func(ctx context.Context, partID int64, r io.Reader, size int64) error
+
+type UploadOption func(*MultiPartialUploader)
+
+// 每读取 n 个新字节时回调，多个分片的进度汇总到同一个回调
+func WithUploadProgress(fn func(n int64)) UploadOption {
+	return func(p *MultiPartialUploader) {
+		p.progress = fn
+	}
+}
+
+// 重试时的退避策略，同 WithBackoff
+func WithUploadBackoff(base, max time.Duration) UploadOption {
+	return func(p *MultiPartialUploader) {
+		p.backoffBase = base
+		p.backoffMax = max
+	}
+}
+
+// 判断错误是否为服务端限流，同 WithThrottleCheck
+func WithUploadThrottleCheck(fn func(error) bool) UploadOption {
+	return func(p *MultiPartialUploader) {
+		p.isThrottled = fn
+	}
+}
+
+// 跳过已经上传完成的分片，用于继续之前中断的上传
+func WithUploadedParts(done func(partID int64) bool) UploadOption {
+	return func(p *MultiPartialUploader) {
+		p.uploaded = done
+	}
+}
+
+// 分片上传成功后回调，用于记录上传进度
+func WithPartDone(fn func(partID int64) error) UploadOption {
+	return func(p *MultiPartialUploader) {
+		p.partDone = fn
+	}
+}
+
+// 多个连接并发上传同一个文件的分片，每个分片独立重试
+// 所有分片完成后由调用方按分片顺序合并
+type MultiPartialUploader struct {
+	reader   io.ReaderAt
+	size     int64
+	partSize int64
+	works    int
+	upFunc   PartUpFunc
+	progress func(n int64)
+	uploaded func(partID int64) bool
+	partDone func(partID int64) error
+
+	retryPolicy
+}
+
+func NewMultiPartialUploader(reader io.ReaderAt, size, partSize int64, works int, fn PartUpFunc, opts ...UploadOption) *MultiPartialUploader {
+	p := &MultiPartialUploader{
+		reader:      reader,
+		size:        size,
+		partSize:    partSize,
+		works:       works,
+		upFunc:      fn,
+		retryPolicy: defaultRetryPolicy(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *MultiPartialUploader) Upload() error {
+	var parts []int64
+	for partID := int64(0); partID*p.partSize < p.size; partID++ {
+		if p.uploaded == nil || !p.uploaded(partID) {
+			parts = append(parts, partID)
+		}
+	}
+	partCount := int64(len(parts))
+	works := p.works
+	if int64(works) > partCount {
+		works = int(partCount)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		next     int64 = -1
+		errOnce  sync.Once
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < works; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				k := atomic.AddInt64(&next, 1)
+				if k >= partCount || ctx.Err() != nil {
+					return
+				}
+				partID := parts[k]
+				start := partID * p.partSize
+				end := start + p.partSize
+				if end > p.size {
+					end = p.size
+				}
+
+				chunk := NewChunk(partID, start, end)
+				open := func() io.Reader {
+					return io.NewSectionReader(p.reader, chunk.start, chunk.Size())
+				}
+				if err := p.uploadPart(ctx, chunk, open); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("part %d, error: %s", partID, err)
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// open 返回分片数据，每次重试都会重新打开
+func (p *MultiPartialUploader) uploadPart(ctx context.Context, chunk *Chunk, open func() io.Reader) error {
+	var reported = chunk.start
+	var err error
+	var attempts int
+	for attempts = 1; attempts <= p.maxAttempts; attempts++ {
+		r := &partReader{
+			r:        open(),
+			offset:   chunk.start,
+			reported: &reported,
+			progress: p.progress,
+		}
+		err = p.upFunc(ctx, chunk.index, r, chunk.Size())
+		if err == nil || attempts == p.maxAttempts {
+			break
+		}
+		if !sleepContext(ctx, p.backoff(attempts, p.throttled(err))) {
+			break
+		}
+	}
+	if err != nil {
+		return &ChunkError{Index: chunk.index, Start: chunk.start, End: chunk.end - 1, Attempts: attempts, Err: err}
+	}
+	if p.partDone != nil {
+		return p.partDone(chunk.index)
+	}
+	return nil
+}
+
+// 重试时会重新读取分片，已经上报过的进度不会重复上报
+type partReader struct {
+	r        io.Reader
+	offset   int64
+	reported *int64
+	progress func(n int64)
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	if r.offset > *r.reported {
+		if r.progress != nil {
+			r.progress(r.offset - *r.reported)
+		}
+		*r.reported = r.offset
+	}
+	return n, err
+}
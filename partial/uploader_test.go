@@ -0,0 +1,243 @@
+package partial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestUpload(t *testing.T) {
+	var parts sync.Map
+	var progress int64
+	var failed sync.Map
+	var running, maxRunning int64
+
+	filedata := []byte(strings.Repeat("hello world", 1024*100))
+	partSize := int64(4096)
+	upload := NewMultiPartialUploader(
+		bytes.NewReader(filedata),
+		int64(len(filedata)),
+		partSize,
+		4,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			n := atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			for {
+				m := atomic.LoadInt64(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+
+			// 每个分片第一次只读取一半就失败
+			if _, loaded := failed.LoadOrStore(partID, true); !loaded {
+				io.CopyN(io.Discard, r, size/2)
+				return errors.New("connection reset")
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if int64(len(data)) != size {
+				t.Errorf("part %d size %d, want %d", partID, len(data), size)
+			}
+			parts.Store(partID, data)
+			return nil
+		},
+		WithUploadBackoff(time.Millisecond, time.Millisecond),
+		WithUploadProgress(func(n int64) {
+			atomic.AddInt64(&progress, n)
+		}),
+	)
+
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 按分片顺序合并
+	var merged []byte
+	for i := int64(0); int64(len(merged)) < int64(len(filedata)); i++ {
+		data, ok := parts.Load(i)
+		if !ok {
+			t.Fatalf("part %d missing", i)
+		}
+		merged = append(merged, data.([]byte)...)
+	}
+	if !bytes.Equal(merged, filedata) {
+		t.Fatal("merged parts differ from file")
+	}
+	if progress != int64(len(filedata)) {
+		t.Fatalf("progress %d, want %d", progress, len(filedata))
+	}
+	if maxRunning < 2 {
+		t.Fatalf("parts were not uploaded concurrently")
+	}
+}
+
+func TestUploadPartError(t *testing.T) {
+	filedata := []byte(strings.Repeat("0123456789", 1024))
+	upload := NewMultiPartialUploader(
+		bytes.NewReader(filedata),
+		int64(len(filedata)),
+		1024,
+		3,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			if partID == 3 {
+				return errors.New("connection reset")
+			}
+			_, err := io.Copy(io.Discard, r)
+			return err
+		},
+		WithUploadBackoff(time.Millisecond, time.Millisecond),
+	)
+
+	err := upload.Upload()
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("expected ChunkError, got %v", err)
+	}
+	if chunkErr.Index != 3 || chunkErr.Start != 3072 || chunkErr.End != 4095 {
+		t.Fatalf("unexpected chunk error: %v", chunkErr)
+	}
+}
+
+func TestUploadSkipUploadedParts(t *testing.T) {
+	var uploaded, done sync.Map
+
+	filedata := []byte(strings.Repeat("0123456789", 1024))
+	upload := NewMultiPartialUploader(
+		bytes.NewReader(filedata),
+		int64(len(filedata)),
+		1024,
+		3,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			if partID%2 == 0 {
+				t.Errorf("part %d uploaded again", partID)
+			}
+			uploaded.Store(partID, true)
+			_, err := io.Copy(io.Discard, r)
+			return err
+		},
+		WithUploadedParts(func(partID int64) bool {
+			return partID%2 == 0
+		}),
+		WithPartDone(func(partID int64) error {
+			done.Store(partID, true)
+			return nil
+		}),
+	)
+
+	if err := upload.Upload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := int64(1); i < 10; i += 2 {
+		if _, ok := done.Load(i); !ok {
+			t.Errorf("part %d not reported as done", i)
+		}
+	}
+}
+
+// 每次最多返回 n 字节，模拟管道读取
+type slowReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	return r.r.Read(p)
+}
+
+func TestUploadStream(t *testing.T) {
+	var parts sync.Map
+	var progress int64
+
+	filedata := []byte(strings.Repeat("0123456789", 1050))
+	upload := NewStreamUploader(
+		1000,
+		3,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if int64(len(data)) != size {
+				t.Errorf("part %d size %d, want %d", partID, len(data), size)
+			}
+			parts.Store(partID, data)
+			return nil
+		},
+		WithUploadProgress(func(n int64) {
+			atomic.AddInt64(&progress, n)
+		}),
+	)
+
+	total, err := upload.UploadStream(&slowReader{r: bytes.NewReader(filedata), n: 333})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if total != int64(len(filedata)) || progress != total {
+		t.Fatalf("total %d progress %d, want %d", total, progress, len(filedata))
+	}
+
+	var merged []byte
+	for i := int64(0); i < 11; i++ {
+		data, ok := parts.Load(i)
+		if !ok {
+			t.Fatalf("part %d missing", i)
+		}
+		merged = append(merged, data.([]byte)...)
+	}
+	if _, ok := parts.Load(int64(11)); ok {
+		t.Fatal("unexpected empty part")
+	}
+	if !bytes.Equal(merged, filedata) {
+		t.Fatal("merged parts differ from stream")
+	}
+}
+
+func TestUploadStreamReadError(t *testing.T) {
+	errRead := errors.New("broken pipe")
+	upload := NewStreamUploader(
+		1000,
+		2,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		},
+	)
+	r := io.MultiReader(strings.NewReader(strings.Repeat("x", 2500)), iotest.ErrReader(errRead))
+	if _, err := upload.UploadStream(r); !errors.Is(err, errRead) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
+
+func TestUploadStreamTooManyParts(t *testing.T) {
+	var uploaded int64
+	upload := NewStreamUploader(
+		1,
+		2,
+		func(ctx context.Context, partID int64, r io.Reader, size int64) error {
+			atomic.AddInt64(&uploaded, 1)
+			_, err := io.Copy(io.Discard, r)
+			return err
+		},
+	)
+	r := strings.NewReader(strings.Repeat("x", MaxStreamParts+1))
+	if _, err := upload.UploadStream(r); !errors.Is(err, ErrTooManyParts) {
+		t.Fatalf("expected too many parts error, got %v", err)
+	}
+	if uploaded > MaxStreamParts {
+		t.Fatalf("uploaded %d parts, limit %d", uploaded, MaxStreamParts)
+	}
+}
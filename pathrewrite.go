@@ -0,0 +1,268 @@
+package upx
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 目标路径中的占位符，例如 /builds/{yyyy}/{mm}/{git-sha}/，未知的 {...} 原样保留
+var templatePattern = regexp.MustCompile(`\{(env:[A-Za-z_][A-Za-z0-9_]*|[a-z-]+)\}`)
+
+// 展开目标路径中的日期、主机名、环境变量和 git 提交占位符，日期使用本地时间
+func expandPathTemplate(s string, now time.Time) (string, error) {
+	var err error
+	gitRev := func(args ...string) string {
+		out, e := exec.Command("git", append([]string{"rev-parse"}, args...)...).Output()
+		if e != nil {
+			err = fmt.Errorf("git rev-parse: %v", e)
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	expanded := templatePattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[1 : len(m)-1]
+		if env, ok := strings.CutPrefix(name, "env:"); ok {
+			v, ok := os.LookupEnv(env)
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %s is not set", env)
+			}
+			return v
+		}
+		switch name {
+		case "yyyy":
+			return now.Format("2006")
+		case "yy":
+			return now.Format("06")
+		case "mm":
+			return now.Format("01")
+		case "dd":
+			return now.Format("02")
+		case "hour":
+			return now.Format("15")
+		case "minute":
+			return now.Format("04")
+		case "second":
+			return now.Format("05")
+		case "date":
+			return now.Format("2006-01-02")
+		case "timestamp":
+			return fmt.Sprint(now.Unix())
+		case "hostname":
+			host, e := os.Hostname()
+			if e != nil && err == nil {
+				err = e
+			}
+			return host
+		case "git-sha":
+			return gitRev("HEAD")
+		case "git-short-sha":
+			return gitRev("--short", "HEAD")
+		}
+		return m
+	})
+	return expanded, err
+}
+
+// sed 风格的 s/from/to/[gi] 改写规则，分隔符可以是 s 之后的任意字符
+type renameRule struct {
+	re     *regexp.Regexp
+	repl   string
+	global bool
+}
+
+func parseRenameRule(expr string) (*renameRule, error) {
+	if len(expr) < 4 || expr[0] != 's' {
+		return nil, fmt.Errorf("invalid rename %q, must be s/from/to/", expr)
+	}
+	delim := expr[1]
+	var (
+		fields []string
+		cur    strings.Builder
+	)
+	for i := 2; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '\\' && i+1 < len(expr) && expr[i+1] == delim:
+			cur.WriteByte(delim)
+			i++
+		case c == delim:
+			fields = append(fields, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid rename %q, must be s/from/to/", expr)
+	}
+
+	rule := &renameRule{repl: sedReplacement(fields[1])}
+	pattern := fields[0]
+	for _, flag := range cur.String() {
+		switch flag {
+		case 'g':
+			rule.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, fmt.Errorf("invalid rename %q: unknown flag %c", expr, flag)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rename %q: %v", expr, err)
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// 把 sed 的替换语法 \1 和 & 转换为 regexp.Expand 的 ${1} 和 ${0}
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if n := s[i]; n >= '0' && n <= '9' {
+				fmt.Fprintf(&b, "${%c}", n)
+			} else if n == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte(n)
+			}
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (r *renameRule) apply(s string) string {
+	if r.global {
+		return r.re.ReplaceAllString(s, r.repl)
+	}
+	loc := r.re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s
+	}
+	return s[:loc[0]] + string(r.re.ExpandString(nil, r.repl, s, loc)) + s[loc[1]:]
+}
+
+// 上传和下载时对目录下文件相对路径的改写，
+// 依次去掉前 strip 级目录、按 renames 改写、flatten 时只保留文件名
+type PathRewriter struct {
+	strip   int
+	renames []*renameRule
+	flatten bool
+}
+
+func NewPathRewriter(strip int, renames []string, flatten bool) (*PathRewriter, error) {
+	if strip < 0 {
+		return nil, fmt.Errorf("invalid --strip-components %d", strip)
+	}
+	r := &PathRewriter{strip: strip, flatten: flatten}
+	for _, expr := range renames {
+		rule, err := parseRenameRule(expr)
+		if err != nil {
+			return nil, err
+		}
+		r.renames = append(r.renames, rule)
+	}
+	if r.strip == 0 && len(r.renames) == 0 && !r.flatten {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// 改写以 / 分隔的相对路径，目录层级不够去掉或者改写后为空、超出目标目录时返回错误，该文件跳过
+func (r *PathRewriter) Rewrite(rel string) (string, error) {
+	if r == nil {
+		return rel, nil
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) <= r.strip {
+		return "", fmt.Errorf("%s: fewer than %d path components", rel, r.strip+1)
+	}
+	to := strings.Join(parts[r.strip:], "/")
+	for _, rule := range r.renames {
+		to = rule.apply(to)
+	}
+	if r.flatten {
+		to = path.Base(to)
+	}
+	to = path.Clean(strings.TrimPrefix(to, "/"))
+	if to == "." || to == ".." || strings.HasPrefix(to, "../") {
+		return "", fmt.Errorf("%s: rewritten to invalid path %q", rel, to)
+	}
+	return to, nil
+}
+
+// 下载时 localDir 下相对路径为 rel 的文件保存到的本地路径
+func (sess *Session) rewriteLocalPath(localDir, rel string) (string, error) {
+	rel, err := sess.rewriter.Rewrite(rel)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(localDir, filepath.FromSlash(cleanFilename(rel))), nil
+}
+
+// 下载时创建云端目录对应的本地目录，改写路径时目录结构会改变，只创建文件需要的目录
+func (sess *Session) mkdirLocal(localDir, rel string) {
+	if sess.rewriter == nil && !sess.dryRun {
+		os.MkdirAll(filepath.Join(localDir, filepath.FromSlash(cleanFilename(rel))), 0755)
+	}
+}
+
+// --dry-run 时只输出源路径到目标路径的映射，返回 true 表示不需要实际传输
+func (sess *Session) dryRunPrint(src, dest string) bool {
+	if sess.dryRun {
+		Print("%s -> %s", src, dest)
+	}
+	return sess.dryRun
+}
+
+// 记录已经分配的目标路径，改写路径后多个源文件可能得到同一个目标路径，
+// 同时传输会互相覆盖或者写坏同一个临时文件，只保留第一个
+type destClaims struct {
+	mu        sync.Mutex
+	dests     map[string]string
+	conflicts int
+}
+
+func newDestClaims() *destClaims {
+	return &destClaims{dests: make(map[string]string)}
+}
+
+// dest 已经分配给其他源文件时返回错误
+func (c *destClaims) claim(dest, src string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if first, ok := c.dests[dest]; ok && first != src {
+		c.conflicts++
+		return fmt.Errorf("%s is also the destination of %s", dest, first)
+	}
+	c.dests[dest] = src
+	return nil
+}
+
+// 有目标路径冲突时返回错误
+func (c *destClaims) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conflicts > 0 {
+		return fmt.Errorf("%d files skipped because of conflicting destinations", c.conflicts)
+	}
+	return nil
+}
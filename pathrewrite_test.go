@@ -0,0 +1,90 @@
+package upx
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPathTemplate(t *testing.T) {
+	now := time.Date(2024, 5, 7, 8, 9, 10, 0, time.Local)
+	host, _ := os.Hostname()
+	t.Setenv("UPX_TEST_BUILD", "42")
+
+	s, err := expandPathTemplate("/builds/{yyyy}/{mm}/{dd}/{hour}{minute}{second}/", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "/builds/2024/05/07/080910/", s)
+
+	s, err = expandPathTemplate("/{date}/{hostname}/{env:UPX_TEST_BUILD}/{unknown}/{yy}", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "/2024-05-07/"+host+"/42/{unknown}/24", s)
+
+	_, err = expandPathTemplate("/{env:UPX_TEST_NOT_SET}/", now)
+	assert.Error(t, err)
+}
+
+func TestParseRenameRule(t *testing.T) {
+	cases := []struct {
+		expr, in, out string
+	}{
+		{"s/a/b/", "a/a.txt", "b/a.txt"},
+		{"s/a/b/g", "a/a.txt", "b/b.txt"},
+		{`s/\.JPEG$/.jpg/i`, "x.jpeg", "x.jpg"},
+		{`s/^(\w+)-(\d+)/\2\/\1/`, "app-10/bin", "10/app/bin"},
+		{"s|^dist/|web/|", "dist/index.html", "web/index.html"},
+		{"s/.*/&.bak/", "a.txt", "a.txt.bak"},
+		{"s/x/$1/", "x", "$1"},
+	}
+	for _, c := range cases {
+		rule, err := parseRenameRule(c.expr)
+		assert.NoError(t, err, c.expr)
+		assert.Equal(t, c.out, rule.apply(c.in), c.expr)
+	}
+
+	for _, expr := range []string{"", "s/a/b", "y/a/b/", "s/a/b/x", "s/(/b/"} {
+		_, err := parseRenameRule(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestPathRewriter(t *testing.T) {
+	r, err := NewPathRewriter(0, nil, false)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	s, err := r.Rewrite("a/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b.txt", s)
+
+	r, err = NewPathRewriter(1, []string{"s/^bin/tools/"}, false)
+	assert.NoError(t, err)
+	s, err = r.Rewrite("linux/bin/upx")
+	assert.NoError(t, err)
+	assert.Equal(t, "tools/upx", s)
+	_, err = r.Rewrite("README.md")
+	assert.Error(t, err)
+
+	r, _ = NewPathRewriter(0, []string{"s/-v[0-9.]*[0-9]//"}, true)
+	s, err = r.Rewrite("dist/linux/upx-v1.2.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "upx.tar.gz", s)
+
+	r, _ = NewPathRewriter(0, []string{"s/^/..\\//"}, false)
+	_, err = r.Rewrite("a.txt")
+	assert.Error(t, err)
+
+	_, err = NewPathRewriter(-1, nil, false)
+	assert.Error(t, err)
+}
+
+func TestDestClaims(t *testing.T) {
+	c := newDestClaims()
+	assert.NoError(t, c.claim("/dst/a.txt", "x/a.txt"))
+	assert.NoError(t, c.claim("/dst/a.txt", "x/a.txt"))
+	assert.NoError(t, c.claim("/dst/b.txt", "y/b.txt"))
+	assert.NoError(t, c.err())
+
+	assert.Error(t, c.claim("/dst/a.txt", "y/a.txt"))
+	assert.Error(t, c.err())
+}
@@ -0,0 +1,105 @@
+package upx
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+	"github.com/upyun/upx/fsutil"
+)
+
+// --preserve 保存文件属性的元信息
+const (
+	MtimeMetaHeader = MetaHeaderPrefix + "Mtime"
+	ModeMetaHeader  = MetaHeaderPrefix + "Mode"
+	UidMetaHeader   = MetaHeaderPrefix + "Uid"
+	GidMetaHeader   = MetaHeaderPrefix + "Gid"
+)
+
+// 上传时需要保存的文件属性，修改时间为纳秒时间戳，权限为八进制，未开启 --preserve 时返回 nil
+func (sess *Session) preserveHeaders(info os.FileInfo) map[string]string {
+	if !sess.preserve {
+		return nil
+	}
+	headers := map[string]string{
+		MtimeMetaHeader: fmt.Sprint(info.ModTime().UnixNano()),
+		ModeMetaHeader:  fmt.Sprintf("%o", info.Mode().Perm()),
+	}
+	if sess.preserveOwner {
+		if uid, gid, ok := fsutil.FileOwner(info); ok {
+			headers[UidMetaHeader] = fmt.Sprint(uid)
+			headers[GidMetaHeader] = fmt.Sprint(gid)
+		}
+	}
+	return headers
+}
+
+// 云端返回的元信息 key 为小写，本地记录的为 header 原样
+func metaValue(meta map[string]string, header string) (string, bool) {
+	if v, ok := meta[strings.ToLower(header)]; ok {
+		return v, true
+	}
+	v, ok := meta[header]
+	return v, ok
+}
+
+// 上传时保存的修改时间
+func preservedMtime(meta map[string]string) (time.Time, bool) {
+	v, ok := metaValue(meta, MtimeMetaHeader)
+	if !ok {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// 列目录的结果中没有元信息，通过 GetInfo 获取，获取失败时返回错误，
+// 不能当作没有元信息处理，否则加密或压缩的文件会按原样保存
+func (sess *Session) withMeta(upPath string, upInfo *upyun.FileInfo) (*upyun.FileInfo, error) {
+	if upInfo.Meta != nil {
+		return upInfo, nil
+	}
+	remote, err := sess.updriver.GetInfo(upPath)
+	if err != nil {
+		return nil, err
+	}
+	info := *upInfo
+	info.Meta = remote.Meta
+	if info.Meta == nil {
+		info.Meta = map[string]string{}
+	}
+	return &info, nil
+}
+
+// 下载完成后恢复上传时保存的权限、属主和修改时间，失败时只输出提示
+func (sess *Session) restoreAttrs(localPath string, upInfo *upyun.FileInfo) {
+	if v, ok := metaValue(upInfo.Meta, ModeMetaHeader); ok {
+		if mode, err := strconv.ParseUint(v, 8, 32); err == nil {
+			if err = os.Chmod(localPath, os.FileMode(mode).Perm()); err != nil {
+				PrintError("chmod %s: %v", localPath, err)
+			}
+		}
+	}
+	if sess.preserveOwner {
+		uidStr, ok1 := metaValue(upInfo.Meta, UidMetaHeader)
+		gidStr, ok2 := metaValue(upInfo.Meta, GidMetaHeader)
+		uid, err1 := strconv.Atoi(uidStr)
+		gid, err2 := strconv.Atoi(gidStr)
+		if ok1 && ok2 && err1 == nil && err2 == nil {
+			if err := fsutil.SetFileOwner(localPath, uid, gid); err != nil {
+				PrintError("chown %s: %v", localPath, err)
+			}
+		}
+	}
+	if mtime, ok := preservedMtime(upInfo.Meta); ok {
+		if err := os.Chtimes(localPath, mtime, mtime); err != nil {
+			PrintError("chtimes %s: %v", localPath, err)
+		}
+	}
+}
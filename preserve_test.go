@@ -0,0 +1,49 @@
+package upx
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+func TestPreserveAttrs(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	assert.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+	assert.NoError(t, os.WriteFile(dst, []byte("data"), 0644))
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	assert.NoError(t, os.Chmod(src, 0600))
+	assert.NoError(t, os.Chtimes(src, mtime, mtime))
+	info, err := os.Stat(src)
+	assert.NoError(t, err)
+
+	sess := &Session{}
+	assert.Nil(t, sess.preserveHeaders(info))
+
+	sess.preserve = true
+	headers := sess.preserveHeaders(info)
+	assert.Equal(t, "600", headers[ModeMetaHeader])
+	assert.NotContains(t, headers, UidMetaHeader)
+
+	// 云端返回的元信息 key 为小写
+	meta := make(map[string]string)
+	for k, v := range headers {
+		meta[strings.ToLower(k)] = v
+	}
+	got, ok := preservedMtime(meta)
+	assert.True(t, ok)
+	assert.True(t, got.Equal(mtime))
+
+	sess.restoreAttrs(dst, &upyun.FileInfo{Meta: meta})
+	restored, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.True(t, restored.ModTime().Equal(mtime))
+	assert.Equal(t, info.Mode().Perm(), restored.Mode().Perm())
+}
@@ -49,6 +49,51 @@ func (p *UpxProcessBar) AddBar(name string, total int64) *mpb.Bar {
 	return bar
 }
 
+// 长度未知的数据流，只显示已传输的字节数和速度
+// 传输完成后需要调用 bar.SetTotal(-1, true)
+func (p *UpxProcessBar) AddStreamBar(name string) *mpb.Bar {
+	if !p.enable {
+		return nil
+	}
+
+	bar := p.process.New(0,
+		mpb.SpinnerStyle(),
+		mpb.PrependDecorators(
+			decor.Name(leftAlign(shortPath(name, 30), 30), decor.WCSyncWidth),
+			decor.Current(decor.SizeB1024(0), "%.2f", decor.WCSyncWidth),
+		),
+		mpb.AppendDecorators(
+			decor.OnComplete(
+				decor.Name("...", decor.WCSyncWidth), " done",
+			),
+			decor.AverageSpeed(decor.SizeB1024(0), " %.1f", decor.WCSyncWidth),
+		))
+	bar.DecoratorAverageAdjust(time.Now())
+	return bar
+}
+
+// 统计已完成的文件数，总数可以随着任务增加通过 bar.SetTotal 调整
+// 全部完成后需要调用 bar.SetTotal(-1, true)
+func (p *UpxProcessBar) AddCountBar(name string) *mpb.Bar {
+	if !p.enable {
+		return nil
+	}
+
+	bar := p.process.AddBar(0,
+		mpb.BarPriority(-1),
+		mpb.PrependDecorators(
+			decor.Name(leftAlign(shortPath(name, 30), 30), decor.WCSyncWidth),
+			decor.CountersNoUnit("%d / %d files", decor.WCSyncWidth),
+		),
+		mpb.AppendDecorators(
+			decor.NewPercentage("%d", decor.WCSyncWidth),
+			decor.OnComplete(
+				decor.Name("...", decor.WCSyncWidth), " done",
+			),
+		))
+	return bar
+}
+
 func (p *UpxProcessBar) Wait() {
 	if p.enable {
 		p.process.Wait()
@@ -174,6 +174,33 @@ func TestPutAndGet(t *testing.T) {
 	lfiles, _ := ioutil.ReadDir(localPath)
 	assert.NotEqual(t, len(lfiles), 0)
 	assert.Equal(t, len(lfiles)+1, len(strings.Split(string(files), "\n")))
+
+	// upx get FILE FILE2 ../put /path/to/dir
+	localPath = filepath.Join(localBase, "many")
+	_, err = Upx("get", "FILE", "FILE2", "../put", localPath)
+	assert.NoError(t, err)
+	for _, name := range []string{"FILE", "FILE2", filepath.Join("put", "FILE")} {
+		_, err = os.Stat(filepath.Join(localPath, name))
+		assert.NoError(t, err)
+	}
+
+	// upx get --from-file list -0 /path/to/dir
+	listPath := filepath.Join(localBase, "list")
+	os.WriteFile(listPath, []byte("FILE3\x00FILE4\x00"), 0644)
+	localPath = filepath.Join(localBase, "fromfile")
+	_, err = Upx("get", "--from-file", listPath, "-0", localPath)
+	assert.NoError(t, err)
+	for _, name := range []string{"FILE3", "FILE4"} {
+		_, err = os.Stat(filepath.Join(localPath, name))
+		assert.NoError(t, err)
+	}
+
+	// 部分路径不存在时其他文件继续下载，最后返回失败
+	localPath = filepath.Join(localBase, "partial")
+	_, err = Upx("get", "NOT-EXIST", "FILE", localPath)
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(localPath, "FILE"))
+	assert.NoError(t, err)
 }
 
 func TestRm(t *testing.T) {
@@ -204,3 +231,26 @@ func TestRm(t *testing.T) {
 	_, err = Upx("ls", "put")
 	assert.Error(t, err)
 }
+
+func TestPutSkipExisting(t *testing.T) {
+	SetUp()
+	defer TearDown()
+
+	upRootPath := path.Join(ROOT, "skip")
+	Upx("mkdir", upRootPath)
+
+	localRootPath, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	CreateFile(filepath.Join(localRootPath, "FILE1"))
+	CreateFile(filepath.Join(localRootPath, "FILE2"))
+
+	b, err := Upx("put", "--skip-existing", "size", localRootPath, upRootPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "uploaded: 2, skipped: 0, failed: 0")
+
+	// 内容不变时全部跳过，新增的文件才会上传
+	CreateFile(filepath.Join(localRootPath, "FILE3"))
+	b, err = Upx("put", "--skip-existing", "md5", localRootPath, upRootPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "uploaded: 1, skipped: 2, failed: 0")
+}
@@ -104,3 +104,40 @@ func TestPutIgnore(t *testing.T) {
 		[]string{"FILE1", "FILE2", ".FILE3", ".FILES"},
 	)
 }
+
+func TestPutUpxIgnore(t *testing.T) {
+	SetUp()
+	defer TearDown()
+
+	upRootPath := path.Join(ROOT, "upxignore")
+	Upx("mkdir", upRootPath)
+
+	localRootPath, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	localRootName := filepath.Base(localRootPath)
+
+	CreateFile(path.Join(localRootPath, "FILE1"))
+	CreateFile(path.Join(localRootPath, "FILE2.tmp"))
+	CreateFile(path.Join(localRootPath, "KEEP.tmp"))
+	CreateFile(path.Join(localRootPath, "node_modules/FILE"))
+	CreateFile(path.Join(localRootPath, "build/FILE"))
+	ioutil.WriteFile(path.Join(localRootPath, ".upxignore"), []byte("*.tmp\n!KEEP.tmp\nnode_modules/\n"), 0644)
+
+	// --show-ignored 只列出被忽略的文件，不上传
+	b, err := Upx("put", "--show-ignored", "--ignore", "build", localRootPath, upRootPath)
+	assert.NoError(t, err)
+	output := string(b)
+	assert.Contains(t, output, "FILE2.tmp")
+	assert.Contains(t, output, "node_modules")
+	assert.Contains(t, output, "build")
+	assert.NotContains(t, output, "KEEP.tmp")
+
+	Upx("put", "--ignore", "build", localRootPath, upRootPath)
+	files, err := Ls(path.Join(upRootPath, localRootName))
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t,
+		files,
+		[]string{"FILE1", "KEEP.tmp"},
+	)
+}
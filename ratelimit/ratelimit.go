@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// 每次读写最多申请的字节数，避免一次大的读写占满令牌桶
+const maxIOSize = 32 * 1024
+
+// 一天中 [Start, End) 时间段内的速率，End 小于 Start 时表示跨过零点
+type Rule struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+func (r Rule) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if r.Start <= r.End {
+		return since >= r.Start && since < r.End
+	}
+	return since >= r.Start || since < r.End
+}
+
+// Limiter 是一个令牌桶，共享同一个 Limiter 的所有 reader/writer 共享带宽
+// 令牌可以透支，透支的部分需要等待补充，桶的容量为一秒的速率
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	rules  []Rule
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// rate 为每秒字节数，小于等于 0 表示不限速；rules 按顺序匹配，都不匹配时使用 rate
+func NewLimiter(rate int64, rules ...Rule) *Limiter {
+	return &Limiter{
+		rate:  rate,
+		rules: rules,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// 当前时间段的速率
+func (l *Limiter) Rate() int64 {
+	return l.rateAt(l.now())
+}
+
+func (l *Limiter) rateAt(t time.Time) int64 {
+	for _, rule := range l.rules {
+		if rule.contains(t) {
+			return rule.Rate
+		}
+	}
+	return l.rate
+}
+
+// 申请 n 个字节的令牌，令牌不足时阻塞
+func (l *Limiter) WaitN(n int) {
+	for n > 0 {
+		l.mu.Lock()
+		now := l.now()
+		rate := l.rateAt(now)
+		if rate <= 0 {
+			l.last = now
+			l.mu.Unlock()
+			return
+		}
+
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		} else {
+			l.tokens = float64(rate)
+		}
+		if l.tokens > float64(rate) {
+			l.tokens = float64(rate)
+		}
+		l.last = now
+
+		take := n
+		if int64(take) > rate {
+			take = int(rate)
+		}
+		l.tokens -= float64(take)
+		var wait time.Duration
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		if wait > 0 {
+			l.sleep(wait)
+		}
+		n -= take
+	}
+}
+
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{r: r, l: l}
+}
+
+func (l *Limiter) Writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &writer{w: w, l: l}
+}
+
+type reader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxIOSize {
+		p = p[:maxIOSize]
+	}
+	n, err := r.r.Read(p)
+	r.l.WaitN(n)
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		size := len(p)
+		if size > maxIOSize {
+			size = maxIOSize
+		}
+		w.l.WaitN(size)
+		n, err := w.w.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[size:]
+	}
+	return written, nil
+}
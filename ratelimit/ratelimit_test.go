@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	c.slept += d
+}
+
+func newFakeLimiter(start time.Time, rate int64, rules ...Rule) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: start}
+	l := NewLimiter(rate, rules...)
+	l.now = clock.now
+	l.sleep = clock.sleep
+	return l, clock
+}
+
+func TestLimiterWaitN(t *testing.T) {
+	l, clock := newFakeLimiter(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), 1000)
+
+	// 第一秒可以使用桶中的令牌，之后每秒 1000 字节
+	for i := 0; i < 10; i++ {
+		l.WaitN(500)
+	}
+	if clock.slept != 4*time.Second {
+		t.Fatalf("slept %v, want 4s", clock.slept)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l, clock := newFakeLimiter(time.Now(), 0)
+	l.WaitN(1 << 30)
+	if clock.slept != 0 {
+		t.Fatalf("slept %v, want 0", clock.slept)
+	}
+}
+
+func TestLimiterSchedule(t *testing.T) {
+	rules := []Rule{
+		{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 1000},
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 0},
+	}
+	l, clock := newFakeLimiter(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), 5000, rules...)
+
+	cases := []struct {
+		hour int
+		want int64
+	}{
+		{10, 1000},
+		{8, 5000},
+		{18, 5000},
+		{23, 0},
+		{3, 0},
+	}
+	for _, c := range cases {
+		clock.t = time.Date(2024, 5, 1, c.hour, 0, 0, 0, time.UTC)
+		if got := l.Rate(); got != c.want {
+			t.Errorf("rate at %02d:00 = %d, want %d", c.hour, got, c.want)
+		}
+	}
+}
+
+func TestReaderWriter(t *testing.T) {
+	l, clock := newFakeLimiter(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), 64*1024)
+
+	data := strings.Repeat("0123456789abcdef", 16*1024)
+	var buf bytes.Buffer
+	if _, err := io.Copy(l.Writer(&buf), l.Reader(strings.NewReader(data))); err != nil {
+		t.Fatal(err.Error())
+	}
+	if buf.String() != data {
+		t.Fatal("data changed after rate limiting")
+	}
+	// 读写共用一个限速器，共 512K 数据，除去第一秒的令牌需要 7 秒
+	if clock.slept != 7*time.Second {
+		t.Fatalf("slept %v, want 7s", clock.slept)
+	}
+}
@@ -1,9 +1,14 @@
 package upx
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -17,13 +22,17 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/upyun/go-sdk/v3/upyun"
+	"github.com/upyun/upx/crypt"
 	"github.com/upyun/upx/fsutil"
 	"github.com/upyun/upx/partial"
 	"github.com/upyun/upx/processbar"
+	"github.com/upyun/upx/ratelimit"
+	"github.com/upyun/upx/xerrors"
 	"github.com/vbauerster/mpb/v8"
 )
 
@@ -34,10 +43,21 @@ const (
 	SYNC_NOT_FOUND
 	DELETE_OK
 	DELETE_FAIL
+	PUT_OK
+	PUT_SKIP
+	PUT_FAIL
 
 	MinResumePutFileSize = 100 * 1024 * 1024
 	DefaultBlockSize     = 10 * 1024 * 1024
 	DefaultResumeRetry   = 10
+
+	// 单个文件默认并发上传的分片数
+	DefaultUploadWorkers = 4
+
+	// --skip-existing 比较云端文件的方式
+	SkipBySize  = "size"
+	SkipByMTime = "mtime"
+	SkipByMD5   = "md5"
 )
 
 type Session struct {
@@ -46,13 +66,81 @@ type Session struct {
 	Password string `json:"password"`
 	CWD      string `json:"cwd"`
 
+	// 默认的限速配置，格式同 --limit-rate
+	LimitRate string `json:"limit_rate,omitempty"`
+
 	updriver *upyun.UpYun
 	color    bool
 
+	// 把下载切片的 context 传给 SDK 发出的请求，见 transport.go
+	transport *contextTransport
+
 	scores    map[int]int
 	smu       sync.RWMutex
 	multipart bool
 
+	// 下载缓冲区可以使用的总内存
+	memoryBudget int64
+
+	// 上传下载时校验 MD5
+	verify bool
+
+	// 所有传输共享的限速器，为 nil 时不限速
+	limiter *ratelimit.Limiter
+
+	// 单个文件并发上传的分片数
+	uploadWorkers int
+
+	// 上传时额外的忽略规则和规则文件，各级目录下的 .upxignore 总是生效
+	ignores     []string
+	ignoreFiles []string
+	ignorer     *fsutil.Ignorer
+
+	// 云端已存在相同的文件时跳过上传，值为 SkipBySize、SkipByMTime 或 SkipByMD5
+	skipExisting string
+
+	// 目标文件已存在时的处理方式，见 resolveConflict
+	onConflict string
+
+	// --on-conflict rename 已经分配出去的路径，避免并发的任务选中同一个编号
+	renamed map[string]bool
+	rmu     sync.Mutex
+
+	// 上传时附加的 HTTP 头
+	headers *UploadHeaders
+
+	// --mime-map 指定的扩展名到 Content-Type 的映射
+	mimeTypes map[string]string
+
+	// 符号链接的处理方式，见 resolveLocalFile
+	symlinks string
+
+	// get --symlinks preserve 时链接的目标不能超出 linkRoot，unsafeLinks 时不检查
+	linkRoot    string
+	unsafeLinks bool
+
+	// 上传时保存文件的修改时间和权限，下载时恢复，preserveOwner 同时保存属主
+	preserve      bool
+	preserveOwner bool
+
+	// 上传时的压缩方式，下载时是否解压压缩上传的文件，见 compress.go
+	compress   string
+	decompress bool
+
+	// 上传时是否加密，encryptKey 为 --key-file 指定的主密钥，下载时用于解密，见 encrypt.go
+	encrypt    bool
+	encryptKey []byte
+
+	// put、upload 和 get 时目录下文件相对路径的改写，为 nil 时不改写，见 pathrewrite.go
+	rewriter *PathRewriter
+
+	// 只输出源路径到目标路径的映射，不实际传输
+	dryRun bool
+
+	// sync 正在同步的目录的真实路径，用于发现指向祖先目录的链接
+	// 目录只在一个 goroutine 中遍历，不需要加锁
+	syncDirs []string
+
 	taskChan chan interface{}
 }
 
@@ -161,6 +249,10 @@ func (sess *Session) FormatUpInfo(upInfo *upyun.FileInfo) string {
 	} else {
 		s += " " + upInfo.Name
 	}
+	// 压缩上传的文件同时显示原始大小，列目录的结果中没有元信息
+	if algo, ok := metaValue(upInfo.Meta, CompressMetaHeader); ok {
+		s += fmt.Sprintf(" (%s, original %d)", algo, originalSize(upInfo))
+	}
 	return s
 }
 
@@ -172,6 +264,8 @@ func (sess *Session) Init() error {
 		Password:  sess.Password,
 		UserAgent: fmt.Sprintf("upx/%s", VERSION),
 	})
+	sess.transport = newContextTransport()
+	sess.updriver.SetHTTPClient(&http.Client{Transport: sess.transport})
 	_, err := sess.updriver.Usage()
 	return err
 }
@@ -238,6 +332,23 @@ func (sess *Session) Ls(upPath string, match *MatchConfig, maxItems int, isDesc
 		return
 	}
 
+	if match.HasRange() {
+		objs := 0
+		err := sess.walkRange(fpath, match, func(fp, rel string, fInfo *upyun.FileInfo) bool {
+			info := *fInfo
+			info.Name = rel
+			if IsMatched(&info, match) {
+				Print(sess.FormatUpInfo(&info))
+				objs++
+			}
+			return maxItems <= 0 || objs < maxItems
+		})
+		if err != nil {
+			PrintErrorAndExit("ls %s: %v", fpath, err)
+		}
+		return
+	}
+
 	fInfoChan := make(chan *upyun.FileInfo, 50)
 	go func() {
 		err := sess.updriver.List(&upyun.GetObjectsConfig{
@@ -260,11 +371,21 @@ func (sess *Session) Ls(upPath string, match *MatchConfig, maxItems int, isDesc
 			break
 		}
 	}
-	if objs == 0 && (match.Wildcard != "" || match.TimeType != TIME_NOT_SET) {
+	if objs == 0 && match.HasFilter() {
 		msg := fpath
 		if match.Wildcard != "" {
 			msg = fpath + "/" + match.Wildcard
 		}
+		for _, rule := range match.Filters {
+			if rule.Include {
+				msg += " +" + rule.Pattern
+			} else {
+				msg += " -" + rule.Pattern
+			}
+		}
+		for _, re := range match.Regexps {
+			msg += " regex@" + re.String()
+		}
 		if match.TimeType != TIME_NOT_SET {
 			msg += " timestamp@"
 			if match.TimeType == TIME_AFTER || match.TimeType == TIME_INTERVAL {
@@ -278,63 +399,102 @@ func (sess *Session) Ls(upPath string, match *MatchConfig, maxItems int, isDesc
 				msg += "+oo]"
 			}
 		}
+		if match.SizeType != SIZE_NOT_SET {
+			msg += " size@"
+			if match.SizeType == SIZE_LARGER || match.SizeType == SIZE_INTERVAL {
+				msg += fmt.Sprintf("[%d,", match.MinSize)
+			} else {
+				msg += "[0,"
+			}
+			if match.SizeType == SIZE_SMALLER || match.SizeType == SIZE_INTERVAL {
+				msg += fmt.Sprintf("%d]", match.MaxSize)
+			} else {
+				msg += "+oo]"
+			}
+		}
 		PrintErrorAndExit("ls: cannot access %s: No such file or directory", msg)
 	}
 }
 
+// 分页列目录，每次最多读取 limit 个对象，从 cursor 处开始
+// 读取完成后将下一页的 cursor 输出到标准错误，没有更多数据时不输出
+func (sess *Session) LsPage(upPath string, match *MatchConfig, limit int, cursor string, isDesc bool) {
+	fpath := sess.AbsPath(upPath)
+	if isDir, exist := sess.IsUpYunDir(fpath); !exist || !isDir {
+		PrintErrorAndExit("ls: cannot access %s: Not a directory", fpath)
+	}
+
+	iter := cursor
+	for read := 0; limit <= 0 || read < limit; {
+		size := upyun.MaxLimit
+		if limit > 0 && limit-read < size {
+			size = limit - read
+		}
+		fInfos, next, err := sess.updriver.ListObjects(&upyun.ListObjectsConfig{
+			Path:         fpath,
+			Iter:         iter,
+			Limit:        size,
+			DescOrder:    isDesc,
+			MaxListTries: 3,
+		})
+		if err != nil {
+			PrintErrorAndExit("ls %s: %v", fpath, err)
+		}
+		for _, fInfo := range fInfos {
+			if IsMatched(fInfo, match) {
+				Print(sess.FormatUpInfo(fInfo))
+			}
+		}
+		read += len(fInfos)
+		iter = next
+		if iter == "" {
+			break
+		}
+	}
+
+	if iter != "" {
+		PrintError("next cursor: %s", iter)
+	}
+}
+
 func (sess *Session) getDir(upPath, localPath string, match *MatchConfig, workers int, resume bool) error {
-	if err := os.MkdirAll(localPath, 0755); err != nil {
-		return err
+	if !sess.dryRun {
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return err
+		}
 	}
 
 	var wg sync.WaitGroup
 
+	claims := newDestClaims()
+	var failed int32
 	fInfoChan := make(chan *upyun.FileInfo, workers*2)
 	wg.Add(workers)
 	for w := 0; w < workers; w++ {
 		go func() {
 			defer wg.Done()
-			var e error
 			for fInfo := range fInfoChan {
 				if IsMatched(fInfo, match) {
 					fpath := path.Join(upPath, fInfo.Name)
-					lpath := filepath.Join(localPath, filepath.FromSlash(cleanFilename(fInfo.Name)))
 					if fInfo.IsDir {
-						os.MkdirAll(lpath, 0755)
-					} else {
-						isContinue := resume
-
-						// 判断本地文件是否存在
-						// 如果存在，大小一致 并且本地文件的最后修改时间大于云端文件的最后修改时间 则跳过该下载
-						// 如果云端文件最后的修改时间大于本地文件的创建时间，则强制重新下载
-						stat, err := os.Stat(lpath)
-						if err == nil {
-							if stat.Size() == fInfo.Size && stat.ModTime().After(fInfo.Time) {
-								continue
-							}
-							if stat.Size() > fInfo.Size {
-								isContinue = false
-							}
-							if fInfo.Time.After(stat.ModTime()) {
-								isContinue = false
-							}
-						}
-
-						for i := 1; i <= MaxRetry; i++ {
-							e = sess.getFileWithProgress(fpath, lpath, fInfo, 1, isContinue, false)
-							if e == nil {
-								break
-							}
-							if upyun.IsNotExist(e) {
-								e = nil
-								break
-							}
-
-							time.Sleep(time.Duration(i*(rand.Intn(MaxJitter-MinJitter)+MinJitter)) * time.Second)
-						}
+						sess.mkdirLocal(localPath, fInfo.Name)
+						continue
+					}
+					lpath, err := sess.rewriteLocalPath(localPath, fInfo.Name)
+					if err != nil {
+						PrintOnlyVerbose("get: skip %s: %v", fpath, err)
+						continue
 					}
-					if e != nil {
-						return
+					if err := claims.claim(lpath, fpath); err != nil {
+						PrintError("get %s: %v", fpath, err)
+						continue
+					}
+					if sess.dryRunPrint(fpath, lpath) {
+						continue
+					}
+					if _, err := sess.getFileRetry(fpath, lpath, fInfo, sess.memoryBudget/int64(workers), resume); err != nil {
+						PrintError("get %s: %v", fpath, err)
+						atomic.AddInt32(&failed, 1)
 					}
 				}
 			}
@@ -348,10 +508,96 @@ func (sess *Session) getDir(upPath, localPath string, match *MatchConfig, worker
 		MaxListLevel: -1,
 	})
 	wg.Wait()
+	if err == nil {
+		err = claims.err()
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d files failed to download", failed)
+	}
 	return err
 }
 
-func (sess *Session) getFileWithProgress(upPath, localPath string, upInfo *upyun.FileInfo, works int, resume, inprogress bool) error {
+// 本地文件已存在时按 --on-conflict 处理，返回实际保存的路径，ok 为 false 时跳过下载
+// 本地是符号链接时按链接本身判断，目标不存在的链接也算已存在
+func (sess *Session) getConflict(localPath string, upInfo *upyun.FileInfo) (string, bool, error) {
+	stat, err := os.Lstat(localPath)
+	if err != nil || sess.onConflict == "" {
+		return localPath, true, nil
+	}
+	return sess.resolveConflict(localConflictFS, localPath, upInfo.Time, stat.ModTime())
+}
+
+// 单线程下载一个文件，失败时重试，云端文件已被删除时不算失败
+// 本地文件大小一致并且比云端文件新时跳过下载，返回 skipped 为 true
+// 指定了 --on-conflict 时按冲突策略处理已存在的本地文件
+// 任何文件都可能是加密或压缩上传的，下载前总是获取元信息
+func (sess *Session) getFileRetry(upPath, localPath string, upInfo *upyun.FileInfo, budget int64, resume bool) (skipped bool, err error) {
+	if upInfo, err = sess.withMeta(upPath, upInfo); err != nil {
+		if upyun.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if err := sess.checkDecrypt(upInfo); err != nil {
+		return false, err
+	}
+	if sess.onConflict != "" {
+		var ok bool
+		if localPath, ok, err = sess.getConflict(localPath, upInfo); err != nil {
+			return false, err
+		} else if !ok {
+			return true, nil
+		}
+	} else if stat, e := os.Stat(localPath); e == nil {
+		// 判断本地文件是否存在
+		// 如果存在，大小一致 并且本地文件的最后修改时间大于云端文件的最后修改时间 则跳过该下载
+		// 如果云端文件最后的修改时间大于本地文件的创建时间，则强制重新下载
+		// --preserve 时本地文件的修改时间会恢复为上传时保存的，与保存的修改时间比较，压缩上传的文件与原始大小比较
+		size := upInfo.Size
+		if sess.decompress || sess.encryptKey != nil {
+			size = originalSize(upInfo)
+		}
+		if mtime, ok := preservedMtime(upInfo.Meta); ok && sess.preserve {
+			if stat.Size() == size && stat.ModTime().Unix() == mtime.Unix() {
+				return true, nil
+			}
+		} else if stat.Size() == size && stat.ModTime().After(upInfo.Time) {
+			return true, nil
+		}
+		if stat.Size() > size || upInfo.Time.After(stat.ModTime()) {
+			resume = false
+		}
+	}
+	if ok, err := sess.getSymlink(upPath, localPath, upInfo); ok || err != nil {
+		return false, err
+	}
+
+	for i := 1; i <= MaxRetry; i++ {
+		err = sess.getFileWithProgress(upPath, localPath, upInfo, 1, budget, resume, false)
+		if err == nil {
+			if err = sess.decryptFile(localPath, upInfo); err != nil {
+				return false, err
+			}
+			if err = sess.decompressFile(localPath, upInfo); err != nil {
+				return false, err
+			}
+			if sess.preserve {
+				sess.restoreAttrs(localPath, upInfo)
+			}
+			return false, nil
+		}
+		if upyun.IsNotExist(err) {
+			return true, nil
+		}
+		if i < MaxRetry {
+			time.Sleep(time.Duration(i*(rand.Intn(MaxJitter-MinJitter)+MinJitter)) * time.Second)
+		}
+	}
+	return false, err
+}
+
+// budget 为该文件下载缓冲区可以使用的内存大小
+func (sess *Session) getFileWithProgress(upPath, localPath string, upInfo *upyun.FileInfo, works int, budget int64, resume, inprogress bool) error {
 	var err error
 
 	var bar *mpb.Bar
@@ -364,35 +610,103 @@ func (sess *Session) getFileWithProgress(upPath, localPath string, upInfo *upyun
 		return err
 	}
 
-	w, err := NewFileWrappedWriter(localPath, bar, resume)
+	// 数据先写入临时文件，状态文件记录已完成的切片，全部完成后再重命名为目标文件
+	tmpPath := localPath + TempFileSuffix
+	statePath := localPath + StateFileSuffix
+
+	var state *partial.State
+	if resume {
+		state, _ = partial.LoadState(statePath)
+		// 远程文件发生了变化，需要重新下载
+		if state != nil && !state.Same(upInfo.Size, upInfo.MD5, upInfo.Time.Unix()) {
+			state = nil
+		}
+		// 临时文件被删除或者被截断时，已完成的切片不可信，需要重新下载
+		if state != nil && state.Completed() > 0 {
+			if stat, err := os.Stat(tmpPath); err != nil || stat.Size() < state.CompletedEnd() || stat.Size() > upInfo.Size {
+				PrintOnlyVerbose("get: %s does not match %s, download again", tmpPath, statePath)
+				state = nil
+			}
+		}
+	}
+	if state == nil {
+		state = partial.NewState(statePath, upInfo.Size, upInfo.MD5, upInfo.Time.Unix(), partial.ChunkSizeFor(upInfo.Size))
+		if err = state.Save(); err != nil {
+			return err
+		}
+	}
+
+	w, err := NewFileWriterAt(tmpPath, state.Completed() > 0)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
 
+	if bar != nil {
+		bar.SetCurrent(state.Completed())
+	}
+
+	var opts []partial.Option
+	opts = append(opts,
+		partial.WithMemoryBudget(budget),
+		partial.WithState(state),
+		partial.WithThrottleCheck(upyun.IsTooManyRequests),
+	)
+
+	// 边下载边计算 MD5，云端没有记录 MD5 时无法校验
+	var hasher hash.Hash
+	if sess.verify && upInfo.MD5 != "" {
+		hasher = md5.New()
+		opts = append(opts, partial.WithHash(hasher, w))
+	}
+	if bar != nil {
+		opts = append(opts, partial.WithProgress(bar.IncrInt64))
+	}
+	if sess.limiter != nil {
+		opts = append(opts, partial.WithRateLimit(sess.limiter.WaitN))
+	}
 	downloader := partial.NewMultiPartialDownloader(
-		localPath,
 		upInfo.Size,
-		partial.DefaultChunkSize,
+		state.ChunkSize,
 		w,
 		works,
-		func(start, end int64) ([]byte, error) {
-			var buffer bytes.Buffer
+		func(ctx context.Context, start, end int64, w io.Writer) error {
 			headers := map[string]string{
 				"Range": fmt.Sprintf("bytes=%d-%d", start, end),
 			}
 			if inprogress {
 				headers["X-Upyun-Multi-In-Progress"] = "true"
 			}
-			_, err = sess.updriver.Get(&upyun.GetObjectConfig{
+			// 切片超时或者下载失败时取消请求，释放连接和缓冲区
+			if sess.transport != nil {
+				defer sess.transport.bind(ctx, headers)()
+			}
+			_, err := sess.updriver.Get(&upyun.GetObjectConfig{
 				Path:    sess.AbsPath(upPath),
-				Writer:  &buffer,
+				Writer:  w,
 				Headers: headers,
 			})
-			return buffer.Bytes(), err
+			return err
 		},
+		opts...,
 	)
 	err = downloader.Download()
+	if err == nil && hasher != nil {
+		if sum := fmt.Sprintf("%x", hasher.Sum(nil)); sum != upInfo.MD5 {
+			// 数据已损坏，下次需要重新下载
+			w.Close()
+			os.Remove(tmpPath)
+			state.Remove()
+			err = fmt.Errorf("%w: %s: local %s, remote %s", xerrors.ErrChecksumMismatch, upPath, sum, upInfo.MD5)
+		}
+	}
+	if err == nil {
+		if err = w.Close(); err == nil {
+			if err = os.Rename(tmpPath, localPath); err == nil {
+				err = state.Remove()
+			}
+		}
+	}
 	if bar != nil {
 		bar.EnableTriggerComplete()
 		if err != nil {
@@ -437,25 +751,310 @@ func (sess *Session) Get(upPath, localPath string, match *MatchConfig, workers i
 				}
 			}
 		}
+		sess.linkRoot = localPath
 		if err := sess.getDir(upPath, localPath, match, workers, resume); err != nil {
 			PrintErrorAndExit(err.Error())
 		}
 	} else {
 		if isDir {
-			localPath = filepath.Join(localPath, cleanFilename(path.Base(upPath)))
+			if localPath, err = sess.rewriteLocalPath(localPath, path.Base(upPath)); err != nil {
+				PrintOnlyVerbose("get: skip %s: %v", upPath, err)
+				return
+			}
+		}
+
+		info := *upInfo
+		info.Name = path.Base(upPath)
+		if !IsMatched(&info, match) {
+			PrintOnlyVerbose("get: skip %s: not matched", upPath)
+			return
+		}
+		if sess.dryRunPrint(upPath, localPath) {
+			return
 		}
+		sess.linkRoot = filepath.Dir(localPath)
 
-		// 小于 100M 不开启多线程
-		if upInfo.Size < 1024*1024*100 || inprogress {
+		// 正在上传的文件不开启多线程，其他文件的并发数由下载器根据切片数和吞吐量调整
+		if inprogress {
 			workers = 1
 		}
-		err := sess.getFileWithProgress(upPath, localPath, upInfo, workers, resume, inprogress)
+		if err = sess.checkDecrypt(upInfo); err != nil {
+			PrintErrorAndExit("get %s: %v", upPath, err)
+		}
+		var ok bool
+		if localPath, ok, err = sess.getConflict(localPath, upInfo); err != nil {
+			PrintErrorAndExit("get %s: %v", upPath, err)
+		} else if !ok {
+			return
+		}
+		if ok, err = sess.getSymlink(upPath, localPath, upInfo); err != nil {
+			PrintErrorAndExit("get %s: %v", upPath, err)
+		} else if ok {
+			return
+		}
+		// 校验失败时重新下载
+		for i := 1; i <= MaxRetry; i++ {
+			err = sess.getFileWithProgress(upPath, localPath, upInfo, workers, sess.memoryBudget, resume, inprogress)
+			if !errors.Is(err, xerrors.ErrChecksumMismatch) {
+				break
+			}
+			PrintError(err.Error())
+		}
 		if err != nil {
 			PrintErrorAndExit(err.Error())
 		}
+		if err = sess.decryptFile(localPath, upInfo); err != nil {
+			PrintErrorAndExit(err.Error())
+		}
+		if err = sess.decompressFile(localPath, upInfo); err != nil {
+			PrintErrorAndExit(err.Error())
+		}
+		if sess.preserve {
+			sess.restoreAttrs(localPath, upInfo)
+		}
+	}
+}
+
+// GetMany 中的一个文件下载任务
+type getJob struct {
+	upPath    string
+	localPath string
+	upInfo    *upyun.FileInfo
+}
+
+// 下载多个远程文件或目录到本地目录 localPath，所有文件共用同一组 workers
+// 单个文件失败不会中断其他文件的下载，全部结束后输出汇总
+func (sess *Session) GetMany(upPaths []string, localPath string, match *MatchConfig, workers int, resume bool) {
+	if localInfo, err := os.Stat(localPath); err == nil && !localInfo.IsDir() {
+		PrintErrorAndExit("get: %s: Not a directory", localPath)
+	}
+	sess.runGetJobs(localPath, workers, resume, func(emit func(*getJob), fail func(string, error)) {
+		for _, upPath := range upPaths {
+			if err := sess.listGetJobs(upPath, localPath, match, emit); err != nil {
+				fail(upPath, err)
+			}
+		}
+	})
+}
+
+// 使用 workers 个并发下载 produce 产生的任务，单个文件失败不会中断其他文件的下载，全部结束后输出汇总
+// produce 中列目录等失败通过 fail 记录，有失败时返回非 0
+func (sess *Session) runGetJobs(localPath string, workers int, resume bool, produce func(emit func(*getJob), fail func(string, error))) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		total     int64
+		succeeded int
+		skipped   int
+		failed    []string
+	)
+	fail := func(fpath string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, fpath)
+		PrintError("get %s: %v", fpath, err)
+	}
+	exitOnFailure := func() {
+		if len(failed) > 0 {
+			for _, fpath := range failed {
+				PrintError("  failed: %s", fpath)
+			}
+			os.Exit(-1)
+		}
+	}
+
+	// 多个云端文件保存到同一个本地路径时只下载第一个，其余的作为失败
+	claims := newDestClaims()
+	claim := func(job *getJob) bool {
+		if err := claims.claim(job.localPath, job.upPath); err != nil {
+			fail(job.upPath, err)
+			return false
+		}
+		return true
+	}
+
+	if sess.dryRun {
+		produce(func(job *getJob) {
+			if claim(job) {
+				sess.dryRunPrint(job.upPath, job.localPath)
+			}
+		}, fail)
+		exitOnFailure()
+		return
+	}
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		PrintErrorAndExit("get: %v", err)
+	}
+	sess.linkRoot = localPath
+
+	countBar := processbar.ProcessBar.AddCountBar("total")
+	jobs := make(chan *getJob, workers*2)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				skip, err := sess.getFileRetry(job.upPath, job.localPath, job.upInfo, sess.memoryBudget/int64(workers), resume)
+				if err != nil {
+					fail(job.upPath, err)
+				} else {
+					mu.Lock()
+					if skip {
+						skipped++
+					} else {
+						succeeded++
+					}
+					mu.Unlock()
+				}
+				if countBar != nil {
+					countBar.Increment()
+				}
+			}
+		}()
+	}
+
+	produce(func(job *getJob) {
+		if !claim(job) {
+			return
+		}
+		total++
+		if countBar != nil {
+			countBar.SetTotal(total, false)
+		}
+		jobs <- job
+	}, fail)
+	close(jobs)
+	wg.Wait()
+	if countBar != nil {
+		countBar.SetTotal(-1, true)
+	}
+
+	Print("get: %d succeeded, %d skipped, %d failed", succeeded, skipped, len(failed))
+	exitOnFailure()
+}
+
+// 展开 upPath 为文件下载任务，目录下的文件保存到 localPath/<目录名> 中
+// 文件名包含通配符 * 时下载父目录中匹配的文件
+func (sess *Session) listGetJobs(upPath, localPath string, match *MatchConfig, emit func(*getJob)) error {
+	upPath = sess.AbsPath(upPath)
+	m := *match
+	if base := path.Base(upPath); strings.Contains(base, "*") {
+		m.Wildcard, upPath = base, path.Dir(upPath)
+	}
+
+	upInfo, err := sess.updriver.GetInfo(upPath)
+	if err != nil {
+		return err
+	}
+	if !upInfo.IsDir {
+		info := *upInfo
+		info.Name = path.Base(upPath)
+		if !IsMatched(&info, &m) {
+			return nil
+		}
+		lpath, err := sess.rewriteLocalPath(localPath, info.Name)
+		if err != nil {
+			PrintOnlyVerbose("get: skip %s: %v", upPath, err)
+			return nil
+		}
+		emit(&getJob{upPath, lpath, upInfo})
+		return nil
+	}
+
+	if m.Wildcard == "" {
+		localPath = filepath.Join(localPath, cleanFilename(path.Base(upPath)))
+	}
+	fInfoChan := make(chan *upyun.FileInfo, 50)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- sess.updriver.List(&upyun.GetObjectsConfig{
+			Path:         upPath,
+			ObjectsChan:  fInfoChan,
+			MaxListTries: 3,
+			MaxListLevel: -1,
+		})
+	}()
+	for fInfo := range fInfoChan {
+		if !IsMatched(fInfo, &m) {
+			continue
+		}
+		fpath := path.Join(upPath, fInfo.Name)
+		if fInfo.IsDir {
+			sess.mkdirLocal(localPath, fInfo.Name)
+			continue
+		}
+		lpath, err := sess.rewriteLocalPath(localPath, fInfo.Name)
+		if err != nil {
+			PrintOnlyVerbose("get: skip %s: %v", fpath, err)
+			continue
+		}
+		emit(&getJob{fpath, lpath, fInfo})
+	}
+	return <-errChan
+}
+
+// 按字典序遍历 upPath 下路径处于 [match.Start, match.End) 区间内的文件和目录
+// 完整处于区间内的目录整体交给 fn 处理，路径是区间端点前缀的目录则继续向下遍历
+// fn 的参数为绝对路径和相对 upPath 的路径，返回 false 时停止遍历
+func (sess *Session) walkRange(upPath string, match *MatchConfig, fn func(fpath, relPath string, fInfo *upyun.FileInfo) bool) error {
+	root := sess.AbsPath(upPath)
+	start, end := match.absRange(root)
+
+	var walk func(dir string) (bool, error)
+	walk = func(dir string) (bool, error) {
+		fInfoChan := make(chan *upyun.FileInfo, 1)
+		objectsConfig := &upyun.GetObjectsConfig{
+			Path:        dir,
+			ObjectsChan: fInfoChan,
+			QuitChan:    make(chan bool, 1),
+		}
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- sess.updriver.List(objectsConfig)
+		}()
+
+		quit := func() {
+			close(objectsConfig.QuitChan)
+			for range fInfoChan {
+			}
+		}
+
+		for fInfo := range fInfoChan {
+			fp := path.Join(dir, fInfo.Name)
+			rel := strings.TrimPrefix(strings.TrimPrefix(fp, root), "/")
+			inRange := (start == "" || fp >= start) && (end == "" || fp < end)
+
+			var goon = true
+			var err error
+			switch {
+			case inRange && fInfo.IsDir && strings.HasPrefix(end, fp+"/"):
+				// 区间的结束位置在该目录内部，只处理目录中的一部分
+				goon, err = walk(fp)
+			case inRange:
+				goon = fn(fp, rel, fInfo)
+			case fInfo.IsDir && strings.HasPrefix(start, fp+"/"):
+				//前缀相同进入下一级文件夹，继续递归判断
+				goon, err = walk(fp)
+			}
+			if err != nil || !goon {
+				quit()
+				return false, err
+			}
+
+			if end != "" && fp >= end && fInfo.IsDir {
+				quit()
+				break
+			}
+		}
+		return true, <-errChan
 	}
+
+	_, err := walk(root)
+	return err
 }
 
+// 下载 upPath 下路径处于 [match.Start, match.End) 区间内的文件，文件按相对 upPath 的路径保存到 localPath 中
+// 所有文件共用同一组 workers，单个文件失败不会中断其他文件的下载
 func (sess *Session) GetStartBetweenEndFiles(upPath, localPath string, match *MatchConfig, workers int) {
 	fpath := sess.AbsPath(upPath)
 	isDir, exist := sess.IsUpYunDir(fpath)
@@ -472,46 +1071,62 @@ func (sess *Session) GetStartBetweenEndFiles(upPath, localPath string, match *Ma
 		}
 	}
 
-	fInfoChan := make(chan *upyun.FileInfo, 1)
-	objectsConfig := &upyun.GetObjectsConfig{
-		Path:        fpath,
-		ObjectsChan: fInfoChan,
-		QuitChan:    make(chan bool, 1),
-	}
-	go func() {
-		err := sess.updriver.List(objectsConfig)
-		if err != nil {
-			PrintErrorAndExit("ls %s: %v", fpath, err)
-		}
-	}()
-
-	startList := match.Start
-	if startList != "" && startList[0] != '/' {
-		startList = filepath.Join(fpath, startList)
-	}
-	endList := match.End
-	if endList != "" && endList[0] != '/' {
-		endList = filepath.Join(fpath, endList)
-	}
-
-	for fInfo := range fInfoChan {
-		fp := filepath.Join(fpath, fInfo.Name)
-		if (fp >= startList || startList == "") && (fp < endList || endList == "") {
-			sess.Get(fp, localPath, match, workers, false, false)
-		} else if strings.HasPrefix(startList, fp) {
-			//前缀相同进入下一级文件夹，继续递归判断
+	// 区间内的文件直接交给下载队列，完整处于区间内的目录列出其中所有的文件
+	sess.runGetJobs(localPath, workers, false, func(emit func(*getJob), fail func(string, error)) {
+		addJob := func(fp, rel string, fInfo *upyun.FileInfo) {
+			info := *fInfo
+			info.Name = rel
+			if !IsMatched(&info, match) {
+				return
+			}
 			if fInfo.IsDir {
-				sess.GetStartBetweenEndFiles(fp, localPath+fInfo.Name+"/", match, workers)
+				sess.mkdirLocal(localPath, rel)
+				return
+			}
+			lpath, err := sess.rewriteLocalPath(localPath, rel)
+			if err != nil {
+				PrintOnlyVerbose("get: skip %s: %v", fp, err)
+				return
 			}
+			emit(&getJob{fp, lpath, fInfo})
 		}
-		if fp >= endList && endList != "" && fInfo.IsDir {
-			close(objectsConfig.QuitChan)
-			break
+		err := sess.walkRange(fpath, match, func(fp, rel string, fInfo *upyun.FileInfo) bool {
+			if !fInfo.IsDir {
+				addJob(fp, rel, fInfo)
+				return true
+			}
+			fInfoChan := make(chan *upyun.FileInfo, 50)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- sess.updriver.List(&upyun.GetObjectsConfig{
+					Path:         fp,
+					ObjectsChan:  fInfoChan,
+					MaxListTries: 3,
+					MaxListLevel: -1,
+				})
+			}()
+			for sub := range fInfoChan {
+				addJob(path.Join(fp, sub.Name), path.Join(rel, sub.Name), sub)
+			}
+			if err := <-errChan; err != nil {
+				fail(fp, err)
+			}
+			return true
+		})
+		if err != nil {
+			fail(fpath, err)
 		}
-	}
+	})
 }
 
 func (sess *Session) putFileWithProgress(localPath, upPath string, localInfo os.FileInfo) error {
+	// 正在上传的文件需要能同时下载，不压缩
+	if !sess.multipart && sess.shouldCompress(localPath, localInfo) {
+		return sess.putCompressedFile(localPath, upPath, localInfo)
+	}
+	if sess.encrypt {
+		return sess.putEncryptedFile(localPath, upPath, localInfo)
+	}
 	var err error
 	fd, err := os.Open(localPath)
 	if err != nil {
@@ -525,49 +1140,241 @@ func (sess *Session) putFileWithProgress(localPath, upPath string, localInfo os.
 		},
 		Reader: fd,
 	}
+	sess.addUploadHeaders(upPath, cfg.Headers)
+	for k, v := range sess.preserveHeaders(localInfo) {
+		cfg.Headers[k] = v
+	}
 
 	var bar *mpb.Bar
 	if IsVerbose {
 		if localInfo.Size() > 0 {
 			bar = processbar.ProcessBar.AddBar(upPath, localInfo.Size())
-			cfg.ProxyReader = func(offset int64, r io.Reader) io.Reader {
-				if offset > 0 {
-					bar.SetCurrent(offset)
-				}
-				return bar.ProxyReader(r)
-			}
 		}
 	} else {
 		log.Printf("file: %s, Start\n", upPath)
 	}
-	if localInfo.Size() >= MinResumePutFileSize || sess.multipart {
+	if bar != nil || sess.limiter != nil {
+		cfg.ProxyReader = func(offset int64, r io.Reader) io.Reader {
+			r = sess.limiter.Reader(r)
+			if bar == nil {
+				return r
+			}
+			if offset > 0 {
+				bar.SetCurrent(offset)
+			}
+			return bar.ProxyReader(r)
+		}
+	}
+	// 正在上传的文件需要按顺序上传才能同时下载，交给 SDK 顺序上传分片
+	// 其他大文件并发上传分片
+	parallel := !sess.multipart && localInfo.Size() >= MinResumePutFileSize
+	if !parallel {
+		if t := sess.fileContentType(upPath, fd); t != "" {
+			cfg.Headers["Content-Type"] = t
+		}
+	}
+	if sess.multipart {
 		cfg.UseResumeUpload = true
 		cfg.ResumePartSize = ResumePartSize(localInfo.Size())
 		cfg.MaxResumePutTries = DefaultResumeRetry
 	}
 
-	err = sess.updriver.Put(cfg)
-	if bar != nil {
-		bar.EnableTriggerComplete()
-		if err != nil {
-			bar.Abort(false)
-		}
+	// 上传时携带 MD5，由服务端校验数据是否完整
+	var localMD5 string
+	if sess.verify {
+		if localMD5, err = md5File(localPath); err != nil {
+			return err
+		}
+		if cfg.UseResumeUpload {
+			cfg.UseMD5 = true
+		} else {
+			cfg.Headers["Content-MD5"] = localMD5
+		}
+	}
+
+	for i := 1; i <= MaxRetry; i++ {
+		if parallel {
+			err = sess.putMultipart(fd, upPath, localInfo, bar, localMD5)
+		} else {
+			err = sess.updriver.Put(cfg)
+		}
+		if err == nil && sess.verify {
+			err = sess.verifyUpload(upPath, localMD5)
+		}
+		if !errors.Is(err, xerrors.ErrChecksumMismatch) {
+			break
+		}
+		log.Println(err)
+		fd.Seek(0, io.SeekStart)
+		if bar != nil {
+			bar.SetCurrent(0)
+		}
+	}
+	if bar != nil {
+		bar.EnableTriggerComplete()
+		if err != nil {
+			bar.Abort(false)
+		}
+	}
+	if !IsVerbose {
+		log.Printf("file: %s, Done\n", upPath)
+	}
+	return err
+}
+
+// 多个连接并发上传分片，所有分片完成后按分片顺序合并
+// localMD5 不为空时由服务端在合并时校验
+// 已完成的分片记录在本地，进程中断后再次上传同一个文件会继续之前的上传
+// 加密上传时上传的是加密后的数据，localMD5 由这里计算
+func (sess *Session) putMultipart(fd *os.File, upPath string, localInfo os.FileInfo, bar *mpb.Bar, localMD5 string) error {
+	size := localInfo.Size()
+	localPath, err := filepath.Abs(fd.Name())
+	if err != nil {
+		return err
+	}
+	recordPath := path.Join(sess.Bucket, upPath)
+
+	record, err := loadUploadRecord(localPath, localInfo, recordPath)
+	if err != nil {
+		PrintOnlyVerbose("load upload record of %s: %v", localPath, err)
+	}
+
+	var src io.ReaderAt = fd
+	meta := sess.preserveHeaders(localInfo)
+	var encMeta map[string]string
+	if sess.encrypt {
+		var prev map[string]string
+		if record != nil {
+			prev = record.Encryption
+		}
+		enc, m, err := sess.encryptFile(fd, localInfo, prev)
+		if err != nil {
+			return err
+		}
+		src, size, encMeta = enc, enc.Size(), m
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		for k, v := range encMeta {
+			meta[k] = v
+		}
+		if sess.verify {
+			if localMD5, err = md5Reader(io.NewSectionReader(enc, 0, size)); err != nil {
+				return err
+			}
+		}
+	}
+	// 数据密钥不同时之前上传的分片不能使用
+	if record != nil && record.Encryption[DataKeyMetaHeader] != encMeta[DataKeyMetaHeader] {
+		record.Remove()
+		record = nil
+	}
+
+	initResult := &upyun.InitMultipartUploadResult{Path: upPath}
+	if record != nil {
+		initResult.UploadID = record.UploadID
+		initResult.PartSize = record.PartSize
+		// 服务端的上传任务可能已经过期，需要重新上传
+		if _, err := sess.updriver.ListMultipartParts(initResult, &upyun.ListMultipartPartsConfig{}); err != nil {
+			record.Remove()
+			record = nil
+		}
+	}
+	if record == nil {
+		contentType := octetStream
+		if !sess.encrypt {
+			contentType = sess.fileContentType(upPath, fd)
+		}
+		initResult, err = sess.updriver.InitMultipartUpload(&upyun.InitMultipartUploadConfig{
+			Path:          upPath,
+			PartSize:      ResumePartSize(size),
+			ContentLength: size,
+			ContentType:   contentType,
+			OrderUpload:   false,
+		})
+		if err != nil {
+			return err
+		}
+		record = newUploadRecord(localPath, localInfo, recordPath, initResult.UploadID, initResult.PartSize)
+		record.Encryption = encMeta
+		if err := record.Save(); err != nil {
+			return err
+		}
+	}
+
+	opts := []partial.UploadOption{
+		partial.WithUploadThrottleCheck(upyun.IsTooManyRequests),
+		partial.WithUploadedParts(record.IsDone),
+		partial.WithPartDone(record.SetDone),
+	}
+	if bar != nil {
+		bar.SetCurrent(record.Uploaded())
+		opts = append(opts, partial.WithUploadProgress(bar.IncrInt64))
+	}
+	workers := sess.uploadWorkers
+	if workers < 1 {
+		workers = DefaultUploadWorkers
+	}
+	uploader := partial.NewMultiPartialUploader(
+		src,
+		size,
+		initResult.PartSize,
+		workers,
+		func(ctx context.Context, partID int64, r io.Reader, n int64) error {
+			return sess.updriver.UploadPart(initResult, &upyun.UploadPartConfig{
+				Reader:   sess.limiter.Reader(r),
+				PartSize: n,
+				PartID:   int(partID),
+			})
+		},
+		opts...,
+	)
+	if err := uploader.Upload(); err != nil {
+		return err
+	}
+	err = sess.updriver.CompleteMultipartUpload(initResult, &upyun.CompleteMultipartUploadConfig{
+		Md5: localMD5,
+	})
+	// 合并失败时分片可能已经损坏，合并完成后上传任务不能再继续，都需要下次重新上传
+	record.Remove()
+	if err != nil {
+		return err
+	}
+	return sess.finishMultipartHeaders(upPath, meta)
+}
+
+func (sess *Session) putMultipartFile(localPath, upPath string, localInfo os.FileInfo, localMD5 string) error {
+	fd, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return sess.putMultipart(fd, upPath, localInfo, nil, localMD5)
+}
+
+// 上传完成后比较云端记录的 MD5，云端没有记录 MD5 时跳过
+func (sess *Session) verifyUpload(upPath, localMD5 string) error {
+	upInfo, err := sess.updriver.GetInfo(upPath)
+	if err != nil {
+		return err
 	}
-	if !IsVerbose {
-		log.Printf("file: %s, Done\n", upPath)
+	if upInfo.MD5 != "" && upInfo.MD5 != localMD5 {
+		return fmt.Errorf("%w: %s: local %s, remote %s", xerrors.ErrChecksumMismatch, upPath, localMD5, upInfo.MD5)
 	}
-	return err
+	return nil
 }
 
-func (sess *Session) putRemoteFileWithProgress(rawURL, upPath string) error {
+func (sess *Session) putRemoteFileWithProgress(rawURL, upPath string, sizeHint int64) error {
 	var size int64
 
 	// 如果可以的话，先从 Head 请求中获取文件长度
 	resp, err := http.Head(rawURL)
-	if err == nil && resp.ContentLength > 0 {
-		size = resp.ContentLength
+	if err == nil {
+		if resp.ContentLength > 0 {
+			size = resp.ContentLength
+		}
+		resp.Body.Close()
 	}
-	resp.Body.Close()
 
 	// 通过get方法获取文件，如果get头中包含Content-Length，则使用get头中的Content-Length
 	resp, err = http.Get(rawURL)
@@ -580,23 +1387,35 @@ func (sess *Session) putRemoteFileWithProgress(rawURL, upPath string) error {
 		size = resp.ContentLength
 	}
 
-	// 如果无法获取 Content-Length 则报错
-	if size == 0 {
-		return fmt.Errorf("get http file Content-Length error: response headers not has Content-Length")
+	// 无法获取 Content-Length 或者需要加密时按数据流分片上传
+	if size == 0 || sess.encrypt {
+		if sizeHint == 0 {
+			sizeHint = size
+		}
+		return sess.putStream(resp.Body, upPath, sizeHint, nil)
+	}
+
+	// 读取开头的内容用于判断 Content-Type，读取的数据会继续上传
+	br := bufio.NewReaderSize(resp.Body, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	headers := map[string]string{
+		"Content-Length": fmt.Sprint(size),
+	}
+	sess.addUploadHeaders(upPath, headers)
+	if t := sess.contentType(upPath, head, resp.Header.Get("Content-Type")); t != "" {
+		headers["Content-Type"] = t
 	}
 
 	// 创建进度条
 	bar := processbar.ProcessBar.AddBar(upPath, size)
-	reader := NewFileWrappedReader(bar, resp.Body)
+	reader := NewFileWrappedReader(bar, io.NopCloser(br))
 
 	// 上传文件
 	err = sess.updriver.Put(&upyun.PutObjectConfig{
-		Path:   upPath,
-		Reader: reader,
-		UseMD5: false,
-		Headers: map[string]string{
-			"Content-Length": fmt.Sprint(size),
-		},
+		Path:    upPath,
+		Reader:  sess.limiter.Reader(reader),
+		UseMD5:  false,
+		Headers: headers,
 	})
 	if bar != nil {
 		bar.EnableTriggerComplete()
@@ -611,24 +1430,177 @@ func (sess *Session) putRemoteFileWithProgress(rawURL, upPath string) error {
 	return nil
 }
 
+// 上传长度未知的数据流，sizeHint 为预估的大小，用于选择分片大小和显示进度
+// 数据不足一个分片时直接上传，否则边读取边分片上传
+// meta 为上传完成后需要设置的元信息
+func (sess *Session) putStream(r io.Reader, upPath string, sizeHint int64, meta map[string]string) error {
+	partSize, err := StreamPartSize(sizeHint)
+	if err != nil {
+		return err
+	}
+	if sess.encrypt {
+		if r, meta, err = sess.encryptStream(r, meta); err != nil {
+			return err
+		}
+	}
+	var bar *mpb.Bar
+	if sizeHint > 0 {
+		bar = processbar.ProcessBar.AddBar(upPath, sizeHint)
+	} else {
+		bar = processbar.ProcessBar.AddStreamBar(upPath)
+	}
+
+	// 数据按顺序经过 hasher，读取完成后即为整个数据流的 MD5
+	hasher := md5.New()
+	br := bufio.NewReaderSize(io.TeeReader(sess.limiter.Reader(r), hasher), int(partSize))
+	head, err := br.Peek(int(partSize))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	var localMD5 string
+	if int64(len(head)) < partSize {
+		cfg := &upyun.PutObjectConfig{
+			Path:   upPath,
+			Reader: bytes.NewReader(head),
+			Headers: map[string]string{
+				"Content-Length": fmt.Sprint(len(head)),
+			},
+		}
+		sess.addUploadHeaders(upPath, cfg.Headers)
+		for k, v := range meta {
+			cfg.Headers[k] = v
+		}
+		if t := sess.contentType(upPath, head, ""); t != "" {
+			cfg.Headers["Content-Type"] = t
+		}
+		if bar != nil {
+			cfg.Reader = bar.ProxyReader(cfg.Reader)
+		}
+		if sess.verify {
+			localMD5 = fmt.Sprintf("%x", hasher.Sum(nil))
+			cfg.Headers["Content-MD5"] = localMD5
+		}
+		err = sess.updriver.Put(cfg)
+	} else {
+		err = sess.putStreamMultipart(br, upPath, partSize, sess.contentType(upPath, head[:min(len(head), sniffLen)], ""), meta, bar, hasher)
+		if err == nil && sess.verify {
+			localMD5 = fmt.Sprintf("%x", hasher.Sum(nil))
+		}
+	}
+	if err == nil && sess.verify {
+		err = sess.verifyUpload(upPath, localMD5)
+	}
+
+	if bar != nil {
+		if err != nil {
+			bar.Abort(false)
+		} else {
+			bar.SetTotal(-1, true)
+		}
+	}
+	return err
+}
+
+func (sess *Session) putStreamMultipart(r io.Reader, upPath string, partSize int64, contentType string, meta map[string]string, bar *mpb.Bar, hasher hash.Hash) error {
+	initResult, err := sess.updriver.InitMultipartUpload(&upyun.InitMultipartUploadConfig{
+		Path:        upPath,
+		PartSize:    partSize,
+		ContentType: contentType,
+		OrderUpload: false,
+	})
+	if err != nil {
+		return err
+	}
+
+	opts := []partial.UploadOption{
+		partial.WithUploadThrottleCheck(upyun.IsTooManyRequests),
+	}
+	if bar != nil {
+		opts = append(opts, partial.WithUploadProgress(bar.IncrInt64))
+	}
+	workers := sess.uploadWorkers
+	if workers < 1 {
+		workers = DefaultUploadWorkers
+	}
+	uploader := partial.NewStreamUploader(
+		initResult.PartSize,
+		workers,
+		func(ctx context.Context, partID int64, r io.Reader, n int64) error {
+			return sess.updriver.UploadPart(initResult, &upyun.UploadPartConfig{
+				Reader:   r,
+				PartSize: n,
+				PartID:   int(partID),
+			})
+		},
+		opts...,
+	)
+	if _, err := uploader.UploadStream(r); err != nil {
+		if errors.Is(err, partial.ErrTooManyParts) {
+			return fmt.Errorf("%v, set a larger --size", err)
+		}
+		return err
+	}
+
+	completeConfig := &upyun.CompleteMultipartUploadConfig{}
+	if sess.verify {
+		completeConfig.Md5 = fmt.Sprintf("%x", hasher.Sum(nil))
+	}
+	if err := sess.updriver.CompleteMultipartUpload(initResult, completeConfig); err != nil {
+		return err
+	}
+	return sess.finishMultipartHeaders(upPath, meta)
+}
+
 func (sess *Session) putFilesWitchProgress(localFiles []*UploadedFile, workers int) {
 	var wg sync.WaitGroup
 
+	// 按远程目录列出已有的文件，key 为完整的远程路径
+	remotes := make(map[string]*upyun.FileInfo)
+	if sess.skipExisting != "" || sess.onConflict != "" {
+		listed := make(map[string]bool)
+		for _, f := range localFiles {
+			dir := path.Dir(f.UpPath)
+			if listed[dir] {
+				continue
+			}
+			listed[dir] = true
+			for name, fInfo := range sess.listRemoteFiles(dir, 0) {
+				remotes[path.Join(dir, name)] = fInfo
+			}
+		}
+	}
+
 	tasks := make(chan *UploadedFile, workers*2)
 	for w := 0; w < workers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for task := range tasks {
-				err := sess.putFileWithProgress(
-					task.LocalPath,
-					task.UpPath,
-					task.LocalInfo,
-				)
+				remote := remotes[task.UpPath]
+				if sess.sameRemoteFile(task.LocalPath, task.LocalInfo, task.UpPath, remote) {
+					PrintOnlyVerbose("upload: skip %s: already exists", task.LocalPath)
+					sess.update(PUT_SKIP)
+					continue
+				}
+				upPath, ok, err := sess.putConflict(task.UpPath, task.LocalInfo, remote)
+				if err == nil {
+					if !ok {
+						sess.update(PUT_SKIP)
+						continue
+					}
+					err = sess.putFileWithProgress(
+						task.LocalPath,
+						upPath,
+						task.LocalInfo,
+					)
+				}
 				if err != nil {
 					fmt.Println("putFileWithProgress error: ", err.Error())
-					return
+					sess.update(PUT_FAIL)
+					continue
 				}
+				sess.update(PUT_OK)
 			}
 		}()
 	}
@@ -641,7 +1613,143 @@ func (sess *Session) putFilesWitchProgress(localFiles []*UploadedFile, workers i
 	wg.Wait()
 }
 
-func (sess *Session) putDir(localPath, upPath string, workers int, withIgnore bool) {
+// 列出远程目录下的文件，key 为相对 upPath 的路径，level 为 -1 时列出所有子目录
+// 目录不存在或者列目录失败时返回空，所有文件都会重新上传
+func (sess *Session) listRemoteFiles(upPath string, level int) map[string]*upyun.FileInfo {
+	remotes := make(map[string]*upyun.FileInfo)
+	fInfoChan := make(chan *upyun.FileInfo, 50)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- sess.updriver.List(&upyun.GetObjectsConfig{
+			Path:         upPath,
+			ObjectsChan:  fInfoChan,
+			MaxListTries: 3,
+			MaxListLevel: level,
+		})
+	}()
+	for fInfo := range fInfoChan {
+		if !fInfo.IsDir {
+			remotes[fInfo.Name] = fInfo
+		}
+	}
+	if err := <-errChan; err != nil && !upyun.IsNotExist(err) {
+		PrintError("list %s: %v", upPath, err)
+	}
+	return remotes
+}
+
+// 根据 --skip-existing 判断云端的文件是否与本地相同，remote 为 nil 时表示云端不存在
+// 大小不一致时一定需要上传，比较 md5 时列目录的结果中没有 MD5，需要再获取一次文件信息
+// 压缩上传时与元信息中的原始大小比较，加密上传时与加密后的大小比较，
+// 这两种情况云端的 MD5 是压缩或加密后数据的，md5 方式总是重新上传
+func (sess *Session) sameRemoteFile(localPath string, localInfo os.FileInfo, upPath string, remote *upyun.FileInfo) bool {
+	if sess.skipExisting == "" || remote == nil || remote.IsDir {
+		return false
+	}
+	transformed := true
+	switch {
+	case !sess.multipart && sess.shouldCompress(localPath, localInfo):
+		info, err := sess.withMeta(upPath, remote)
+		if err != nil || originalSize(info) != localInfo.Size() {
+			return false
+		}
+		remote = info
+	case sess.encrypt:
+		if remote.Size != crypt.EncryptedSize(localInfo.Size(), crypt.DefaultChunkSize) {
+			return false
+		}
+	default:
+		if remote.Size != localInfo.Size() {
+			return false
+		}
+		transformed = false
+	}
+	switch sess.skipExisting {
+	case SkipBySize:
+		return true
+	case SkipByMTime:
+		return !localInfo.ModTime().After(remote.Time)
+	case SkipByMD5:
+		if transformed {
+			return false
+		}
+		if remote.MD5 == "" {
+			info, err := sess.updriver.GetInfo(upPath)
+			if err != nil {
+				return false
+			}
+			remote = info
+		}
+		localMD5, err := md5File(localPath)
+		return err == nil && remote.MD5 != "" && localMD5 == remote.MD5
+	}
+	return false
+}
+
+// 云端文件已存在时按 --on-conflict 处理，remote 为 nil 时表示云端不存在
+func (sess *Session) putConflict(upPath string, localInfo os.FileInfo, remote *upyun.FileInfo) (string, bool, error) {
+	if sess.onConflict == "" || remote == nil || remote.IsDir {
+		return upPath, true, nil
+	}
+	return sess.resolveConflict(sess.remoteConflictFS(), upPath, localInfo.ModTime(), remote.Time)
+}
+
+// 输出上传、跳过和失败的文件数，有失败时返回非 0，--dry-run 时只在有失败时输出
+func (sess *Session) putSummary() {
+	sess.smu.RLock()
+	msg := fmt.Sprintf("uploaded: %d, skipped: %d, failed: %d", sess.scores[PUT_OK], sess.scores[PUT_SKIP], sess.scores[PUT_FAIL])
+	failed := sess.scores[PUT_FAIL]
+	sess.smu.RUnlock()
+	if sess.dryRun && failed == 0 {
+		return
+	}
+	if failed > 0 {
+		PrintErrorAndExit(msg)
+	}
+	Print(msg)
+}
+
+// 上传目录 root 时使用的忽略规则
+func (sess *Session) newIgnorer(root string) *fsutil.Ignorer {
+	ignorer, err := fsutil.NewIgnorer(root, sess.ignores, sess.ignoreFiles)
+	if err != nil {
+		PrintErrorAndExit("read ignore file: %v", err)
+	}
+	return ignorer
+}
+
+// 列出本地路径下被忽略的文件和目录，不进行上传
+func (sess *Session) ShowIgnored(localPaths []string, withIgnore bool) {
+	for _, localPath := range localPaths {
+		absPath, err := filepath.Abs(localPath)
+		if err != nil {
+			PrintErrorAndExit(err.Error())
+		}
+		ignorer := sess.newIgnorer(absPath)
+		err = filepath.Walk(absPath, func(fpath string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fpath == absPath {
+				return nil
+			}
+			if (!withIgnore && fsutil.IsIgnoreFile(fpath, info)) || ignorer.Ignored(fpath, info.IsDir()) {
+				if info.IsDir() {
+					Print("%s%c", fpath, filepath.Separator)
+					return filepath.SkipDir
+				}
+				Print("%s", fpath)
+			}
+			return nil
+		})
+		if err != nil {
+			PrintErrorAndExit("show ignored %s: %v", localPath, err)
+		}
+	}
+}
+
+// claims 记录已经分配的云端路径，同一次上传的多个目录共用
+func (sess *Session) putDir(localPath, upPath string, workers int, withIgnore bool, claims *destClaims) {
 	localAbsPath, err := filepath.Abs(localPath)
 	if err != nil {
 		PrintErrorAndExit(err.Error())
@@ -654,10 +1762,18 @@ func (sess *Session) putDir(localPath, upPath string, workers int, withIgnore bo
 	if !withIgnore && fsutil.IsIgnoreFile(localAbsPath, rootDirInfo) {
 		PrintErrorAndExit("%s is a ignore dir, use `-all` to force put all files", localAbsPath)
 	}
+	ignorer := sess.newIgnorer(localAbsPath)
+
+	// 一次列出云端目录下的所有文件，避免每个文件都请求一次 GetInfo
+	var remotes map[string]*upyun.FileInfo
+	if (sess.skipExisting != "" || sess.onConflict != "") && !sess.dryRun {
+		remotes = sess.listRemoteFiles(upPath, -1)
+	}
 
 	type FileInfo struct {
 		fpath string
 		fInfo os.FileInfo
+		link  string
 	}
 	localFiles := make(chan *FileInfo, workers*2)
 	var wg sync.WaitGroup
@@ -667,37 +1783,88 @@ func (sess *Session) putDir(localPath, upPath string, workers int, withIgnore bo
 			defer wg.Done()
 			for info := range localFiles {
 				rel, _ := filepath.Rel(localAbsPath, info.fpath)
-				desPath := path.Join(upPath, filepath.ToSlash(rel))
-				fInfo, err := os.Stat(info.fpath)
-				if err == nil && fInfo.IsDir() {
+				isDir := info.fInfo.IsDir()
+				// 改写路径时目录结构会改变，云端目录在上传文件时自动创建
+				if isDir && (sess.rewriter != nil || sess.dryRun) {
+					continue
+				}
+				upRel, err := sess.rewriter.Rewrite(filepath.ToSlash(rel))
+				if err != nil {
+					PrintOnlyVerbose("put: skip %s: %v", info.fpath, err)
+					sess.update(PUT_SKIP)
+					continue
+				}
+				desPath := path.Join(upPath, upRel)
+				if !isDir {
+					if err := claims.claim(desPath, info.fpath); err != nil {
+						PrintError("put %s: %v", info.fpath, err)
+						sess.update(PUT_FAIL)
+						continue
+					}
+				}
+				if sess.dryRunPrint(info.fpath, desPath) {
+					continue
+				}
+				if isDir {
 					err = sess.updriver.Mkdir(desPath)
 				} else {
-					err = sess.putFileWithProgress(info.fpath, desPath, info.fInfo)
+					remote := remotes[upRel]
+					if sess.sameRemoteFile(info.fpath, info.fInfo, desPath, remote) {
+						PrintOnlyVerbose("put: skip %s: already exists", info.fpath)
+						sess.update(PUT_SKIP)
+						continue
+					}
+					var ok bool
+					if desPath, ok, err = sess.putConflict(desPath, info.fInfo, remote); err == nil {
+						if !ok {
+							sess.update(PUT_SKIP)
+							continue
+						}
+						if info.link != "" {
+							err = sess.putSymlink(desPath, info.link)
+						} else {
+							err = sess.putFileWithProgress(info.fpath, desPath, info.fInfo)
+						}
+					}
 				}
 				if err != nil {
 					log.Printf("put %s to %s error: %s", info.fpath, desPath, err)
+					if !isDir {
+						sess.update(PUT_FAIL)
+					}
 					if upyun.IsTooManyRequests(err) {
 						time.Sleep(time.Second)
-						continue
 					}
-					return
+					continue
+				}
+				if !isDir {
+					sess.update(PUT_OK)
 				}
 			}
 		}()
 	}
 
-	filepath.Walk(localAbsPath, func(path string, info fs.FileInfo, err error) error {
+	fsutil.Walk(localAbsPath, sess.symlinks == SymlinkFollow, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
+			// 跟随链接时，形成环的和目标不存在的链接跳过
+			if info != nil && (errors.Is(err, fsutil.ErrSymlinkLoop) || isSymlink(info)) {
+				PrintError("skip %s: %v", path, err)
+				sess.update(PUT_SKIP)
+				return nil
+			}
 			return err
 		}
-		if !withIgnore && fsutil.IsIgnoreFile(path, info) {
+		if (!withIgnore && fsutil.IsIgnoreFile(path, info)) || ignorer.Ignored(path, info.IsDir()) {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
+		} else if fInfo, link := sess.resolveLocalFile(path, info); fInfo == nil {
+			sess.update(PUT_SKIP)
 		} else {
 			localFiles <- &FileInfo{
 				fpath: path,
-				fInfo: info,
+				fInfo: fInfo,
+				link:  link,
 			}
 		}
 		return nil
@@ -708,7 +1875,8 @@ func (sess *Session) putDir(localPath, upPath string, workers int, withIgnore bo
 }
 
 // / Put 上传单文件或单目录
-func (sess *Session) Put(localPath, upPath string, workers int, withIgnore, inprogress bool) {
+// sizeHint 为数据流或者没有 Content-Length 的 url 预估的大小
+func (sess *Session) Put(localPath, upPath string, workers int, withIgnore, inprogress bool, sizeHint int64) {
 	upPath = sess.AbsPath(upPath)
 	if inprogress {
 		sess.multipart = true
@@ -742,13 +1910,30 @@ func (sess *Session) Put(localPath, upPath string, workers int, withIgnore, inpr
 				PrintErrorAndExit("missing file name in the url, must has remote path name")
 			}
 		}
-		err := sess.putRemoteFileWithProgress(localPath, upPath)
+		if sess.dryRunPrint(localPath, upPath) {
+			return
+		}
+		err := sess.putRemoteFileWithProgress(localPath, upPath, sizeHint)
 		if err != nil {
 			PrintErrorAndExit(err.Error())
 		}
 		return
 	}
 
+	// 从标准输入读取数据
+	if localPath == "-" {
+		if isDir {
+			PrintErrorAndExit("put: missing file name of stdin, must has remote path name")
+		}
+		if sess.dryRunPrint(localPath, upPath) {
+			return
+		}
+		if err := sess.putStream(os.Stdin, upPath, sizeHint, nil); err != nil {
+			PrintErrorAndExit("put stdin to %s: %v", upPath, err)
+		}
+		return
+	}
+
 	localInfo, err := os.Stat(localPath)
 	if err != nil {
 		PrintErrorAndExit("stat %s: %v", localPath, err)
@@ -762,12 +1947,39 @@ func (sess *Session) Put(localPath, upPath string, workers int, withIgnore, inpr
 				upPath = path.Join(upPath, filepath.Base(localPath))
 			}
 		}
-		sess.putDir(localPath, upPath, workers, withIgnore)
+		sess.putDir(localPath, upPath, workers, withIgnore, newDestClaims())
+		sess.putSummary()
 	} else {
 		if isDir {
-			upPath = path.Join(upPath, filepath.Base(localPath))
+			name, err := sess.rewriter.Rewrite(filepath.Base(localPath))
+			if err != nil {
+				PrintOnlyVerbose("put: skip %s: %v", localPath, err)
+				return
+			}
+			upPath = path.Join(upPath, name)
+		}
+		if sess.dryRunPrint(localPath, upPath) {
+			return
+		}
+		if sess.skipExisting != "" || sess.onConflict != "" {
+			remote, _ := sess.updriver.GetInfo(upPath)
+			if sess.sameRemoteFile(localPath, localInfo, upPath, remote) {
+				Print("put: skip %s: already exists", localPath)
+				return
+			}
+			var ok bool
+			var err error
+			if upPath, ok, err = sess.putConflict(upPath, localInfo, remote); err != nil {
+				PrintErrorAndExit("put %s: %v", localPath, err)
+			} else if !ok {
+				return
+			}
 		}
-		sess.putFileWithProgress(localPath, upPath, localInfo)
+		if err := sess.putFileWithProgress(localPath, upPath, localInfo); err != nil {
+			sess.update(PUT_FAIL)
+			PrintErrorAndExit("put %s: %v", localPath, err)
+		}
+		sess.update(PUT_OK)
 	}
 }
 
@@ -786,6 +1998,8 @@ func (sess *Session) Upload(filenames []string, upPath string, workers int, with
 		PrintErrorAndExit("upload: %s: Not a directory", upPath)
 	}
 
+	// 改写路径后多个本地文件可能上传到同一个云端路径，只上传第一个
+	claims := newDestClaims()
 	var (
 		dirs         []string
 		uploadedFile []*UploadedFile
@@ -799,10 +2013,27 @@ func (sess *Session) Upload(filenames []string, upPath string, workers int, with
 		if localInfo.IsDir() {
 			dirs = append(dirs, filename)
 		} else {
+			if absPath, _ := filepath.Abs(filename); sess.newIgnorer(filepath.Dir(absPath)).Ignored(absPath, false) {
+				PrintOnlyVerbose("upload: skip %s: ignored", filename)
+				continue
+			}
+			name, err := sess.rewriter.Rewrite(filepath.Base(filename))
+			if err != nil {
+				PrintOnlyVerbose("upload: skip %s: %v", filename, err)
+				continue
+			}
+			if err := claims.claim(path.Join(upPath, name), filename); err != nil {
+				PrintError("upload %s: %v", filename, err)
+				sess.update(PUT_FAIL)
+				continue
+			}
+			if sess.dryRunPrint(filename, path.Join(upPath, name)) {
+				continue
+			}
 			uploadedFile = append(uploadedFile, &UploadedFile{
 				barId:     -1,
 				LocalPath: filename,
-				UpPath:    path.Join(upPath, filepath.Base(filename)),
+				UpPath:    path.Join(upPath, name),
 				LocalInfo: localInfo,
 			})
 		}
@@ -815,11 +2046,15 @@ func (sess *Session) Upload(filenames []string, upPath string, workers int, with
 			path.Join(upPath, filepath.Base(localPath)),
 			workers,
 			withIgnore,
+			claims,
 		)
 	}
 
 	// 上传文件
-	sess.putFilesWitchProgress(uploadedFile, workers)
+	if !sess.dryRun {
+		sess.putFilesWitchProgress(uploadedFile, workers)
+	}
+	sess.putSummary()
 }
 
 func (sess *Session) rm(fpath string, isAsync bool, isFolder bool) {
@@ -882,7 +2117,32 @@ func (sess *Session) Rm(upPath string, match *MatchConfig, isAsync bool) {
 		}
 	}
 
-	if isDir && match != nil && match.Wildcard == "" {
+	if match != nil && match.HasRange() {
+		if !isDir {
+			PrintErrorAndExit("rm: cannot remove %s: Not a directory", fpath)
+		}
+		err := sess.walkRange(fpath, match, func(fp, rel string, fInfo *upyun.FileInfo) bool {
+			info := *fInfo
+			info.Name = rel
+			if IsMatched(&info, match) {
+				if fInfo.IsDir {
+					if match.SizeType != SIZE_NOT_SET {
+						return true
+					}
+					sess.rmDir(fp, isAsync)
+				} else {
+					sess.rmFile(fp, isAsync)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			PrintErrorAndExit("ls %s: %v", fpath, err)
+		}
+		return
+	}
+
+	if isDir && match != nil && !match.HasFilter() {
 		if match.ItemType == FILE {
 			PrintErrorAndExit("rm: cannot remove %s: Is a directory, add -d/-a flag", fpath)
 		}
@@ -916,6 +2176,10 @@ func (sess *Session) Rm(upPath string, match *MatchConfig, isAsync bool) {
 		fp := path.Join(fpath, fInfo.Name)
 		if IsMatched(fInfo, match) {
 			if fInfo.IsDir {
+				// 目录不参与大小匹配，按大小删除时不删除整个目录
+				if match.SizeType != SIZE_NOT_SET {
+					continue
+				}
 				sess.rmDir(fp, isAsync)
 			} else {
 				sess.rmFile(fp, isAsync)
@@ -924,11 +2188,30 @@ func (sess *Session) Rm(upPath string, match *MatchConfig, isAsync bool) {
 	}
 }
 
-func (sess *Session) tree(upPath, prefix string, output chan string) (folders, files int, err error) {
+func (sess *Session) tree(upPath, relPath, prefix string, match *MatchConfig, output chan string) (folders, files int, err error) {
+	listInfos := make(chan *upyun.FileInfo, 50)
 	upInfos := make(chan *upyun.FileInfo, 50)
 	fpath := sess.AbsPath(upPath)
 	wg := sync.WaitGroup{}
-	wg.Add(1)
+	wg.Add(2)
+
+	// 目录总是展示，只按 include/exclude 规则裁剪；文件需要满足全部匹配条件
+	go func() {
+		defer wg.Done()
+		defer close(upInfos)
+		for fInfo := range listInfos {
+			info := *fInfo
+			info.Name = path.Join(relPath, fInfo.Name)
+			if fInfo.IsDir {
+				if len(match.Filters) > 0 && !matchFilterRules(match.Filters, info.Name, true) {
+					continue
+				}
+			} else if !IsMatched(&info, match) {
+				continue
+			}
+			upInfos <- fInfo
+		}
+	}()
 
 	go func() {
 		defer wg.Done()
@@ -942,7 +2225,7 @@ func (sess *Session) tree(upPath, prefix string, output chan string) (folders, f
 					output <- p + prevInfo.Name
 				}
 				folders++
-				d, f, _ := sess.tree(path.Join(fpath, prevInfo.Name), prefix+"!   ", output)
+				d, f, _ := sess.tree(path.Join(fpath, prevInfo.Name), path.Join(relPath, prevInfo.Name), prefix+"!   ", match, output)
 				folders += d
 				files += f
 			} else {
@@ -962,7 +2245,7 @@ func (sess *Session) tree(upPath, prefix string, output chan string) (folders, f
 				output <- p + prevInfo.Name
 			}
 			folders++
-			d, f, _ := sess.tree(path.Join(fpath, prevInfo.Name), prefix+"    ", output)
+			d, f, _ := sess.tree(path.Join(fpath, prevInfo.Name), path.Join(relPath, prevInfo.Name), prefix+"    ", match, output)
 			folders += d
 			files += f
 		} else {
@@ -973,13 +2256,13 @@ func (sess *Session) tree(upPath, prefix string, output chan string) (folders, f
 
 	err = sess.updriver.List(&upyun.GetObjectsConfig{
 		Path:        fpath,
-		ObjectsChan: upInfos,
+		ObjectsChan: listInfos,
 	})
 	wg.Wait()
 	return
 }
 
-func (sess *Session) Tree(upPath string) {
+func (sess *Session) Tree(upPath string, match *MatchConfig) {
 	fpath := sess.AbsPath(upPath)
 	files, folders := 0, 0
 	defer func() {
@@ -993,7 +2276,7 @@ func (sess *Session) Tree(upPath string) {
 
 	output := make(chan string, 50)
 	go func() {
-		folders, files, _ = sess.tree(fpath, "", output)
+		folders, files, _ = sess.tree(fpath, "", "", match, output)
 		close(output)
 	}()
 
@@ -1004,6 +2287,11 @@ func (sess *Session) Tree(upPath string) {
 }
 
 func (sess *Session) syncFile(localPath, upPath string, strongCheck bool) (status int, err error) {
+	if sess.symlinks == SymlinkPreserve {
+		if info, err := os.Lstat(localPath); err == nil && isSymlink(info) {
+			return sess.syncSymlink(localPath, upPath, info)
+		}
+	}
 	curMeta, err := makeDBValue(localPath, false)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -1041,8 +2329,55 @@ func (sess *Session) syncFile(localPath, upPath string, strongCheck bool) (statu
 			}
 		}
 	}
+	localInfo, err := os.Stat(localPath)
+	if err != nil {
+		return SYNC_FAIL, err
+	}
+	cfg := &upyun.PutObjectConfig{
+		Path:      upPath,
+		LocalPath: localPath,
+		Headers:   map[string]string{},
+	}
+	sess.addUploadHeaders(upPath, cfg.Headers)
+	for k, v := range sess.preserveHeaders(localInfo) {
+		cfg.Headers[k] = v
+	}
+	if localInfo.Size() < MinResumePutFileSize {
+		if fd, err := os.Open(localPath); err == nil {
+			if t := sess.fileContentType(upPath, fd); t != "" {
+				cfg.Headers["Content-Type"] = t
+			}
+			fd.Close()
+		}
+	}
+	if sess.verify {
+		if curMeta.Md5 == "" {
+			curMeta.Md5, _ = md5File(localPath)
+		}
+		cfg.Headers["Content-MD5"] = curMeta.Md5
+	}
+	if sess.limiter != nil {
+		// 限速后请求体不再是文件，需要指定长度
+		cfg.Headers["Content-Length"] = fmt.Sprint(localInfo.Size())
+		cfg.ProxyReader = func(offset int64, r io.Reader) io.Reader {
+			return sess.limiter.Reader(r)
+		}
+	}
+	// 压缩和加密上传时在上传过程中校验上传数据的 MD5
+	compress := sess.shouldCompress(localPath, localInfo)
 	for i := 1; i <= MaxRetry; i++ {
-		err = sess.updriver.Put(&upyun.PutObjectConfig{Path: upPath, LocalPath: localPath})
+		if compress {
+			err = sess.putCompressedFile(localPath, upPath, localInfo)
+		} else if sess.encrypt {
+			err = sess.putEncryptedFile(localPath, upPath, localInfo)
+		} else if localInfo.Size() >= MinResumePutFileSize {
+			err = sess.putMultipartFile(localPath, upPath, localInfo, curMeta.Md5)
+		} else {
+			err = sess.updriver.Put(cfg)
+		}
+		if err == nil && sess.verify && !compress && !sess.encrypt {
+			err = sess.verifyUpload(upPath, curMeta.Md5)
+		}
 		if err == nil {
 			break
 		}
@@ -1072,6 +2407,16 @@ func (sess *Session) syncObject(localPath, upPath string, isDir bool) {
 	}
 }
 
+func (sess *Session) filterIgnored(dir string, metas []*fileMeta) []*fileMeta {
+	var res []*fileMeta
+	for _, meta := range metas {
+		if !sess.ignorer.Ignored(filepath.Join(dir, meta.Name), meta.IsDir) {
+			res = append(res, meta)
+		}
+	}
+	return res
+}
+
 func (sess *Session) syncDirectory(localPath, upPath string) (int, error) {
 	delFunc := func(prevMeta *fileMeta) {
 		sess.taskChan <- &delTask{
@@ -1086,6 +2431,11 @@ func (sess *Session) syncDirectory(localPath, upPath string) (int, error) {
 		sess.syncObject(src, dest, curMeta.IsDir)
 	}
 
+	if real, err := filepath.EvalSymlinks(localPath); err == nil {
+		sess.syncDirs = append(sess.syncDirs, real)
+		defer func() { sess.syncDirs = sess.syncDirs[:len(sess.syncDirs)-1] }()
+	}
+
 	dbVal, err := getDBValue(localPath, upPath)
 	if err != nil {
 		return SYNC_FAIL, err
@@ -1099,11 +2449,14 @@ func (sess *Session) syncDirectory(localPath, upPath string) (int, error) {
 		}
 		return SYNC_FAIL, err
 	}
+	// 被忽略的文件不上传，之前已经同步过的也不会被 --delete 删除
+	curMetas = sess.filterIgnored(localPath, curMetas)
+	curMetas = sess.filterLinks(localPath, curMetas)
 
 	status := SYNC_EXISTS
 	var prevMetas []*fileMeta
 	if dbVal != nil && dbVal.IsDir == "true" {
-		prevMetas = dbVal.Items
+		prevMetas = sess.filterIgnored(localPath, dbVal.Items)
 	} else {
 		if err = sess.updriver.Mkdir(upPath); err != nil {
 			return SYNC_FAIL, err
@@ -1149,6 +2502,7 @@ func (sess *Session) Sync(localPath, upPath string, workers int, delete, strong
 
 	upPath = sess.AbsPath(upPath)
 	localPath, _ = filepath.Abs(localPath)
+	sess.ignorer = sess.newIgnorer(localPath)
 
 	if err := initDB(); err != nil {
 		PrintErrorAndExit("sync: init database: %v", err)
@@ -1284,14 +2638,89 @@ func (sess *Session) Purge(urls []string, file string) {
 	}
 }
 
-func (sess *Session) Copy(srcPath, destPath string, force bool) error {
+func (sess *Session) Copy(srcPath, destPath string, match *MatchConfig, force bool) error {
+	if match.HasRange() {
+		return sess.copyMoveRange(srcPath, destPath, "copy", match, force)
+	}
 	return sess.copyMove(srcPath, destPath, "copy", force)
 }
 
-func (sess *Session) Move(srcPath, destPath string, force bool) error {
+func (sess *Session) Move(srcPath, destPath string, match *MatchConfig, force bool) error {
+	if match.HasRange() {
+		return sess.copyMoveRange(srcPath, destPath, "move", match, force)
+	}
 	return sess.copyMove(srcPath, destPath, "move", force)
 }
 
+// 将 srcPath 目录下处于区间内的文件移动或者复制到 destPath 目录，保持相对路径不变
+func (sess *Session) copyMoveRange(srcPath, destPath, method string, match *MatchConfig, force bool) error {
+	srcPath = sess.AbsPath(srcPath)
+	destPath = sess.AbsPath(destPath)
+	if isDir, exist := sess.IsUpYunDir(srcPath); !exist || !isDir {
+		return fmt.Errorf("source path %s is not a directory", srcPath)
+	}
+
+	failed := 0
+	handle := func(fp, rel string) {
+		dest := path.Join(destPath, rel)
+		if err := sess.copyMove(fp, dest, method, force); err != nil {
+			PrintError("%s %s to %s FAIL %v", method, fp, dest, err)
+			failed++
+		} else {
+			PrintOnlyVerbose("%s %s to %s OK", method, fp, dest)
+		}
+	}
+
+	err := sess.walkRange(srcPath, match, func(fp, rel string, fInfo *upyun.FileInfo) bool {
+		if !fInfo.IsDir {
+			info := *fInfo
+			info.Name = rel
+			if IsMatched(&info, match) {
+				handle(fp, rel)
+			}
+			return true
+		}
+
+		// 区间内的目录，先列出其中所有文件再逐个处理，避免移动时影响列目录
+		var files []*upyun.FileInfo
+		fInfoChan := make(chan *upyun.FileInfo, 50)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- sess.updriver.List(&upyun.GetObjectsConfig{
+				Path:         fp,
+				ObjectsChan:  fInfoChan,
+				MaxListTries: 3,
+				MaxListLevel: -1,
+			})
+		}()
+		for f := range fInfoChan {
+			if !f.IsDir {
+				files = append(files, f)
+			}
+		}
+		if err := <-errChan; err != nil {
+			PrintError("ls %s: %v", fp, err)
+			failed++
+			return true
+		}
+		for _, f := range files {
+			info := *f
+			info.Name = path.Join(rel, f.Name)
+			if IsMatched(&info, match) {
+				handle(path.Join(fp, f.Name), info.Name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s: %d files failed", method, failed)
+	}
+	return nil
+}
+
 // 移动或者复制
 // method: "move" | "copy"
 // force: 是否覆盖目标文件
@@ -1320,15 +2749,17 @@ func (sess *Session) copyMove(srcPath, destPath, method string, force bool) erro
 		return err
 	}
 	// 如果没有错误，表示文件存在，则检测文件类型，并判断是否允许覆盖
+	var existed *upyun.FileInfo
 	if err == nil {
 		if !destFileInfo.IsDir {
 			// 如果目标文件是文件类型，则需要使用强制覆盖
-			if !force {
+			if !force && sess.onConflict == "" {
 				return fmt.Errorf(
 					"target path %s already exists use -f to force overwrite",
 					destPath,
 				)
 			}
+			existed = destFileInfo
 		} else {
 			// 补全文件名后，再次检测文件存不存在
 			destPath = path.Join(destPath, path.Base(srcPath))
@@ -1340,12 +2771,13 @@ func (sess *Session) copyMove(srcPath, destPath, method string, force bool) erro
 						destPath,
 					)
 				}
-				if !force {
+				if !force && sess.onConflict == "" {
 					return fmt.Errorf(
 						"target file %s already exists use -f to force overwrite",
 						destPath,
 					)
 				}
+				existed = destFileInfo
 			}
 		}
 	}
@@ -1358,6 +2790,15 @@ func (sess *Session) copyMove(srcPath, destPath, method string, force bool) erro
 		)
 	}
 
+	// 指定了 --on-conflict 时，按冲突策略处理已存在的目标文件
+	if existed != nil && sess.onConflict != "" {
+		dest, ok, err := sess.resolveConflict(sess.remoteConflictFS(), destPath, sourceFileInfo.Time, existed.Time)
+		if err != nil || !ok {
+			return err
+		}
+		destPath = dest
+	}
+
 	switch method {
 	case "copy":
 		return sess.updriver.Copy(&upyun.CopyObjectConfig{
@@ -0,0 +1,191 @@
+package upx
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+	"github.com/upyun/upx/fsutil"
+)
+
+// --symlinks 符号链接的处理方式，未指定时保持各命令原来的行为
+const (
+	SymlinkFollow   = "follow"
+	SymlinkSkip     = "skip"
+	SymlinkPreserve = "preserve"
+
+	// preserve 时保存链接目标的元信息
+	SymlinkMetaHeader = MetaHeaderPrefix + "Symlink"
+)
+
+var symlinkPolicies = []string{SymlinkFollow, SymlinkSkip, SymlinkPreserve}
+
+func isSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// 按 --symlinks 处理遍历到的本地文件，info 为 Lstat 的结果
+// 返回需要上传的文件信息，nil 表示跳过，保留链接时 link 为链接的目标
+// 特殊文件总是跳过
+func (sess *Session) resolveLocalFile(fpath string, info os.FileInfo) (fi os.FileInfo, link string) {
+	if isSymlink(info) {
+		switch sess.symlinks {
+		case SymlinkSkip:
+			PrintOnlyVerbose("skip symlink %s", fpath)
+			return nil, ""
+		case SymlinkPreserve:
+			target, err := os.Readlink(fpath)
+			if err != nil {
+				PrintError("skip %s: %v", fpath, err)
+				return nil, ""
+			}
+			return info, target
+		}
+		st, err := os.Stat(fpath)
+		if err != nil {
+			PrintError("skip %s: %v", fpath, err)
+			return nil, ""
+		}
+		info = st
+	}
+	if fsutil.IsSpecialFile(info) {
+		PrintError("skip special file %s", fpath)
+		return nil, ""
+	}
+	return info, ""
+}
+
+// 上传一个空文件表示符号链接，链接的目标保存在元信息中
+func (sess *Session) putSymlink(upPath, target string) error {
+	return sess.updriver.Put(&upyun.PutObjectConfig{
+		Path:   upPath,
+		Reader: bytes.NewReader(nil),
+		Headers: map[string]string{
+			"Content-Length":  "0",
+			SymlinkMetaHeader: url.PathEscape(target),
+		},
+	})
+}
+
+// --symlinks preserve 时将保存了链接目标的空文件还原为符号链接，返回是否是链接
+func (sess *Session) getSymlink(upPath, localPath string, upInfo *upyun.FileInfo) (bool, error) {
+	if sess.symlinks != SymlinkPreserve || upInfo.IsDir || upInfo.Size != 0 {
+		return false, nil
+	}
+	upInfo, err := sess.withMeta(upPath, upInfo)
+	if err != nil {
+		return true, err
+	}
+	target, ok := metaValue(upInfo.Meta, SymlinkMetaHeader)
+	if !ok {
+		return false, nil
+	}
+	target, err = url.PathUnescape(target)
+	if err != nil {
+		return true, err
+	}
+	if !sess.unsafeLinks && !linkInside(sess.linkRoot, localPath, target) {
+		return true, fmt.Errorf("symlink %s -> %s points outside %s, use --unsafe-links to restore it", localPath, target, sess.linkRoot)
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return true, err
+	}
+	if info, err := os.Lstat(localPath); err == nil && !info.IsDir() {
+		os.Remove(localPath)
+	}
+	if err := os.Symlink(target, localPath); err != nil {
+		return true, err
+	}
+	PrintOnlyVerbose("link %s -> %s", localPath, target)
+	return true, nil
+}
+
+// 判断位于 localPath 的链接指向的 target 是否在 root 目录内，绝对路径的目标总是视为在外部
+// 否则下载到链接指向的目录中的文件会写到 root 以外
+func linkInside(root, localPath, target string) bool {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return false
+	}
+	if root == "" {
+		root = filepath.Dir(localPath)
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(localPath), target))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 判断 sync 遇到的指向目录的链接是否指向正在同步的祖先目录
+func (sess *Session) isSyncParent(fpath string) bool {
+	real, err := filepath.EvalSymlinks(fpath)
+	if err != nil {
+		return false
+	}
+	return contains(sess.syncDirs, real)
+}
+
+// 按 --symlinks 处理 sync 目录中的符号链接和特殊文件，未指定时同 follow
+func (sess *Session) filterLinks(dir string, metas []*fileMeta) []*fileMeta {
+	var res []*fileMeta
+	for _, meta := range metas {
+		fpath := filepath.Join(dir, meta.Name)
+		info, err := os.Lstat(fpath)
+		if err != nil {
+			res = append(res, meta)
+			continue
+		}
+		if isSymlink(info) {
+			switch sess.symlinks {
+			case SymlinkSkip:
+				PrintOnlyVerbose("sync: skip symlink %s", fpath)
+				continue
+			case SymlinkPreserve:
+				meta.IsDir = false
+				res = append(res, meta)
+				continue
+			}
+			if info, err = os.Stat(fpath); err != nil {
+				PrintError("sync: skip %s: %v", fpath, err)
+				continue
+			}
+			if info.IsDir() && sess.isSyncParent(fpath) {
+				PrintError("sync: skip %s: %v", fpath, fsutil.ErrSymlinkLoop)
+				continue
+			}
+		}
+		if fsutil.IsSpecialFile(info) {
+			PrintError("sync: skip special file %s", fpath)
+			continue
+		}
+		res = append(res, meta)
+	}
+	return res
+}
+
+// 同步符号链接，链接目标没有变化时跳过
+func (sess *Session) syncSymlink(localPath, upPath string, info os.FileInfo) (int, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return SYNC_FAIL, err
+	}
+	curMeta := &dbValue{
+		ModifyTime: info.ModTime().UnixNano(),
+		Md5:        fmt.Sprintf("%x", md5.Sum([]byte(target))),
+	}
+	prevMeta, err := getDBValue(localPath, upPath)
+	if err != nil {
+		return SYNC_FAIL, err
+	}
+	if prevMeta != nil && prevMeta.Md5 == curMeta.Md5 {
+		setDBValue(localPath, upPath, curMeta)
+		return SYNC_EXISTS, nil
+	}
+	if err = sess.putSymlink(upPath, target); err != nil {
+		return SYNC_FAIL, err
+	}
+	setDBValue(localPath, upPath, curMeta)
+	return SYNC_OK, nil
+}
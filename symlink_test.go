@@ -0,0 +1,62 @@
+package upx
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutSymlinks(t *testing.T) {
+	SetUp()
+	defer TearDown()
+
+	upRootPath := path.Join(ROOT, "symlinks")
+	Upx("mkdir", upRootPath)
+
+	localRootPath := t.TempDir()
+	localRootName := filepath.Base(localRootPath)
+	CreateFile(path.Join(localRootPath, "FILE"))
+	CreateFile(path.Join(localRootPath, "dir/FILE"))
+	assert.NoError(t, os.Symlink("FILE", path.Join(localRootPath, "LINK")))
+	assert.NoError(t, os.Symlink("dir", path.Join(localRootPath, "DIRLINK")))
+	assert.NoError(t, os.Symlink("..", path.Join(localRootPath, "dir/LOOP")))
+
+	// skip 不上传链接
+	Upx("put", "--symlinks", "skip", localRootPath, path.Join(upRootPath, "skip"))
+	files, err := Ls(path.Join(upRootPath, "skip", localRootName))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, files, []string{"FILE", "dir"})
+
+	// follow 进入指向目录的链接，跳过形成环的链接
+	Upx("put", "--symlinks", "follow", localRootPath, path.Join(upRootPath, "follow"))
+	files, err = Ls(path.Join(upRootPath, "follow", localRootName, "DIRLINK"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, files, []string{"FILE"})
+
+	// preserve 上传后下载还原为链接
+	Upx("put", "--symlinks", "preserve", localRootPath, path.Join(upRootPath, "preserve"))
+	localGetPath := t.TempDir()
+	Upx("get", "--symlinks", "preserve", path.Join(upRootPath, "preserve", localRootName), localGetPath)
+	target, err := os.Readlink(path.Join(localGetPath, localRootName, "LINK"))
+	assert.NoError(t, err)
+	assert.Equal(t, "FILE", target)
+	target, err = os.Readlink(path.Join(localGetPath, localRootName, "dir/LOOP"))
+	assert.NoError(t, err)
+	assert.Equal(t, "..", target)
+}
+
+func TestLinkInside(t *testing.T) {
+	root := filepath.Join("restore", "project")
+	localPath := filepath.Join(root, "dir", "LINK")
+	assert.True(t, linkInside(root, localPath, "FILE"))
+	assert.True(t, linkInside(root, localPath, "../FILE"))
+	assert.True(t, linkInside(root, localPath, ".."))
+	assert.False(t, linkInside(root, localPath, "../.."))
+	assert.False(t, linkInside(root, localPath, "../../../etc"))
+	assert.False(t, linkInside(root, localPath, "/etc/passwd"))
+	assert.True(t, linkInside("", localPath, "FILE"))
+	assert.False(t, linkInside("", localPath, "../FILE"))
+}
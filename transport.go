@@ -0,0 +1,66 @@
+package upx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SDK 的接口不支持 context，请求通过该请求头携带 context 的编号，
+// 由 contextTransport 取出并绑定到请求上，context 取消时连接和正在读取的响应会被关闭
+const contextIDHeader = "X-Upx-Context-Id"
+
+// 同 SDK 默认的连接超时
+const connectTimeout = 60 * time.Second
+
+type contextTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	seq  int64
+	ctxs map[string]context.Context
+}
+
+func newContextTransport() *contextTransport {
+	return &contextTransport{
+		base: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: connectTimeout}).DialContext,
+		},
+		ctxs: make(map[string]context.Context),
+	}
+}
+
+// 在请求头中记录 ctx 的编号，返回的函数在请求结束后调用
+func (t *contextTransport) bind(ctx context.Context, headers map[string]string) func() {
+	t.mu.Lock()
+	t.seq++
+	id := strconv.FormatInt(t.seq, 10)
+	t.ctxs[id] = ctx
+	t.mu.Unlock()
+
+	headers[contextIDHeader] = id
+	return func() {
+		t.mu.Lock()
+		delete(t.ctxs, id)
+		t.mu.Unlock()
+	}
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := req.Header.Get(contextIDHeader)
+	if id == "" {
+		return t.base.RoundTrip(req)
+	}
+	t.mu.Lock()
+	ctx := t.ctxs[id]
+	t.mu.Unlock()
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	r := req.Clone(ctx)
+	r.Header.Del(contextIDHeader)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,51 @@
+package upx
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextTransportCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get(contextIDHeader))
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		// 客户端取消后连接被关闭，请求才会结束
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	transport := newContextTransport()
+	client := &http.Client{Transport: transport}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	headers := map[string]string{}
+	release := transport.bind(ctx, headers)
+	defer release()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("body read not cancelled")
+	}
+}
@@ -2,6 +2,7 @@ package upx
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -22,9 +23,10 @@ func TestTree(t *testing.T) {
 			dirs = append(dirs, fmt.Sprintf("dir%d", i))
 		}
 
-		CreateFile("FILE")
+		localFile := filepath.Join(t.TempDir(), "FILE")
+		CreateFile(localFile)
 		for i := 0; i < 5; i++ {
-			Upx("put", "FILE", fmt.Sprintf("FILE%d", i))
+			Upx("put", localFile, fmt.Sprintf("FILE%d", i))
 			files = append(files, fmt.Sprintf("FILE%d", i))
 		}
 	}()
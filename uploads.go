@@ -0,0 +1,194 @@
+package upx
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/upyun/go-sdk/v3/upyun"
+)
+
+// 分片上传的断点记录，每个上传任务保存为 getUploadsDir() 下的一个文件
+// 以本地路径、文件大小、修改时间和远程路径作为 key，本地文件变化后记录失效
+type uploadRecord struct {
+	LocalPath string `json:"local_path"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mod_time"`
+	UpPath    string `json:"up_path"`
+	UploadID  string `json:"upload_id"`
+	PartSize  int64  `json:"part_size"`
+	Parts     []int  `json:"parts"`
+	CreatedAt int64  `json:"created_at"`
+
+	// 加密上传时的加密参数，续传时使用相同的数据密钥
+	Encryption map[string]string `json:"encryption,omitempty"`
+
+	id string
+	mu sync.Mutex
+}
+
+func getUploadsDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("USERPROFILE"), ".upx.uploads")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".upx.uploads")
+}
+
+func makeUploadID(localPath string, localInfo os.FileInfo, upPath string) string {
+	key := fmt.Sprintf("%s\n%d\n%d\n%s", localPath, localInfo.Size(), localInfo.ModTime().UnixNano(), upPath)
+	return fmt.Sprintf("%x", md5.Sum([]byte(key)))
+}
+
+// upPath 为包含 bucket 的完整路径，本地路径需要是绝对路径
+func newUploadRecord(localPath string, localInfo os.FileInfo, upPath, uploadID string, partSize int64) *uploadRecord {
+	return &uploadRecord{
+		LocalPath: localPath,
+		Size:      localInfo.Size(),
+		ModTime:   localInfo.ModTime().UnixNano(),
+		UpPath:    upPath,
+		UploadID:  uploadID,
+		PartSize:  partSize,
+		CreatedAt: time.Now().Unix(),
+		id:        makeUploadID(localPath, localInfo, upPath),
+	}
+}
+
+// 没有对应的记录时返回 nil
+func loadUploadRecord(localPath string, localInfo os.FileInfo, upPath string) (*uploadRecord, error) {
+	return readUploadRecord(makeUploadID(localPath, localInfo, upPath))
+}
+
+func readUploadRecord(id string) (*uploadRecord, error) {
+	b, err := os.ReadFile(filepath.Join(getUploadsDir(), id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	record := &uploadRecord{id: id}
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func listUploadRecords() ([]*uploadRecord, error) {
+	entries, err := os.ReadDir(getUploadsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var records []*uploadRecord
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		record, err := readUploadRecord(entry.Name())
+		if err != nil {
+			PrintError("read upload record %s: %v", entry.Name(), err)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt < records[j].CreatedAt
+	})
+	return records, nil
+}
+
+func (r *uploadRecord) Bucket() string {
+	return strings.SplitN(strings.TrimPrefix(r.UpPath, "/"), "/", 2)[0]
+}
+
+// 去掉 bucket 之后的远程路径
+func (r *uploadRecord) Path() string {
+	return path.Join("/", strings.TrimPrefix(strings.TrimPrefix(r.UpPath, "/"), r.Bucket()))
+}
+
+func (r *uploadRecord) IsDone(partID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range r.Parts {
+		if int64(id) == partID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *uploadRecord) SetDone(partID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Parts = append(r.Parts, int(partID))
+	return r.save()
+}
+
+// 已经上传完成的字节数
+func (r *uploadRecord) Uploaded() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, id := range r.Parts {
+		size := r.Size - int64(id)*r.PartSize
+		if size > r.PartSize {
+			size = r.PartSize
+		}
+		n += size
+	}
+	return n
+}
+
+func (r *uploadRecord) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.save()
+}
+
+func (r *uploadRecord) save() error {
+	if err := os.MkdirAll(getUploadsDir(), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fpath := filepath.Join(getUploadsDir(), r.id)
+	if err := os.WriteFile(fpath+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(fpath+".tmp", fpath)
+}
+
+func (r *uploadRecord) Remove() error {
+	err := os.Remove(filepath.Join(getUploadsDir(), r.id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 列出当前服务中服务端还没有完成的分片上传，包括本地记录已经丢失的上传
+func (sess *Session) listServerUploads() ([]*upyun.MultipartUploadFile, error) {
+	result, err := sess.updriver.ListMultipartUploads(&upyun.ListMultipartConfig{})
+	if err != nil {
+		return nil, err
+	}
+	var files []*upyun.MultipartUploadFile
+	for _, f := range result.Files {
+		if !f.Completed {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
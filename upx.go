@@ -23,6 +23,7 @@ func CreateUpxApp() *cli.App {
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{Name: "quiet, q", Usage: "not verbose"},
 		cli.StringFlag{Name: "auth", Usage: "auth string"},
+		cli.StringFlag{Name: "limit-rate", Usage: "limit transfer rate of all commands, e.g. 5M or 09:00-18:00=1M,5M"},
 	}
 	app.Before = func(c *cli.Context) error {
 		if c.Bool("q") {
@@ -60,6 +61,7 @@ func CreateUpxApp() *cli.App {
 		NewUpgradeCommand(),
 		NewCopyCommand(),
 		NewMoveCommand(),
+		NewUploadsCommand(),
 	}
 	return app
 }
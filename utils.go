@@ -10,30 +10,267 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/upyun/upx/partial"
+	"github.com/upyun/upx/ratelimit"
 )
 
-func parseMTime(value string, match *MatchConfig) error {
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// 解析时区，支持 Local、UTC、IANA 时区名以及 +08:00 形式的偏移
+func parseTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		t, err := time.Parse("-07:00", name)
+		if err != nil {
+			return nil, err
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(name)
+}
+
+// 在 time.ParseDuration 的基础上支持 d（天）和 w（周）
+func parseDuration(value string) (time.Duration, error) {
+	var total time.Duration
+	s := value
+	for s != "" {
+		i := strings.IndexAny(s, "dw")
+		if i < 0 {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return 0, err
+			}
+			return total + d, nil
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		unit := 24 * time.Hour
+		if s[i] == 'w' {
+			unit *= 7
+		}
+		total += time.Duration(n * float64(unit))
+		s = s[i+1:]
+	}
+	return total, nil
+}
+
+// 解析时间表达式：
+//   - 绝对时间 2024-05-01、2024-05-01T08:00、RFC3339，未带时区的按 loc 解析
+//   - @1714521600 形式的 unix 时间戳
+//   - 相对时间 30m、2h、7d，表示当前时间之前的时刻
+//   - now
+func parseTimeExpr(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return time.Now(), nil
+	}
+	if strings.HasPrefix(value, "@") {
+		v, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(v, 0), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	d, err := parseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// 解析 --mtime：
+//   - [+-]n，与 find -mtime 相同，单位为天，+n 表示 n+1 天之前
+//   - [+-]n 带 s/m/h/d/w 单位时不取整，例如 +2h 表示 2 小时之前，2h 表示 2 到 3 小时之前
+//   - start..end 表示时间区间，两端为 parseTimeExpr 支持的表达式，可以省略其中一端，
+//     只有日期的 end 包含当天
+func parseMTime(value string, loc *time.Location, match *MatchConfig) error {
 	if value == "" {
 		return nil
 	}
 
-	v, err := strconv.ParseInt(value, 10, 64)
+	if idx := strings.Index(value, ".."); idx >= 0 {
+		start, end := value[:idx], value[idx+2:]
+		if start == "" && end == "" {
+			return fmt.Errorf("invalid time range %q", value)
+		}
+		if start != "" {
+			t, err := parseTimeExpr(start, loc)
+			if err != nil {
+				return err
+			}
+			match.SetAfter(t)
+		}
+		if end != "" {
+			t, err := parseTimeExpr(end, loc)
+			if err != nil {
+				return err
+			}
+			if _, e := time.ParseInLocation("2006-01-02", strings.TrimSpace(end), loc); e == nil {
+				t = t.AddDate(0, 0, 1)
+			}
+			match.SetBefore(t)
+		}
+		return nil
+	}
+
+	unit := time.Hour * 24
+	// 不带单位时与 find 相同按天取整
+	round := int64(0)
+	num := value[:len(value)-1]
+	switch value[len(value)-1] {
+	case 's':
+		unit = time.Second
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = time.Hour * 24
+	case 'w':
+		unit = time.Hour * 24 * 7
+	default:
+		num = value
+		round = 1
+	}
+
+	v, err := strconv.ParseInt(num, 10, 64)
 	if err != nil {
 		return err
 	}
 
+	now := time.Now()
 	if v < 0 {
-		match.After = time.Now().Add(time.Duration(v) * time.Hour * 24)
-		match.TimeType = TIME_AFTER
+		match.SetAfter(now.Add(time.Duration(v) * unit))
 	} else {
 		if strings.HasPrefix(value, "+") {
-			match.Before = time.Now().Add(time.Duration(-1*(v+1)) * time.Hour * 24)
-			match.TimeType = TIME_BEFORE
+			match.SetBefore(now.Add(time.Duration(-1*(v+round)) * unit))
 		} else {
-			match.Before = time.Now().Add(time.Duration(-1*v) * time.Hour * 24)
-			match.After = time.Now().Add(time.Duration(-1*(v+1)) * time.Hour * 24)
-			match.TimeType = TIME_INTERVAL
+			match.SetBefore(now.Add(time.Duration(-1*v) * unit))
+			match.SetAfter(now.Add(time.Duration(-1*(v+1)) * unit))
+		}
+	}
+	return nil
+}
+
+// 解析文件大小，支持 K/M/G/T 后缀（1024 进制），例如 512, 10K, 1.5M, 2GB
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(v * float64(unit)), nil
+}
+
+// 解析限速配置，例如 "5M" 或 "09:00-18:00=1M,5M"
+// 逗号分隔的每一项为默认速率或 "HH:MM-HH:MM=速率"，时间段按顺序匹配，速率为 0 表示不限速
+func parseLimitRate(value string) (*ratelimit.Limiter, error) {
+	var rate int64
+	var rules []ratelimit.Rule
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		period, size, ok := strings.Cut(item, "=")
+		if !ok {
+			v, err := parseSize(item)
+			if err != nil {
+				return nil, err
+			}
+			rate = v
+			continue
+		}
+
+		startStr, endStr, ok := strings.Cut(period, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q", period)
+		}
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(endStr)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, ratelimit.Rule{Start: start, End: end, Rate: v})
+	}
+	return ratelimit.NewLimiter(rate, rules...), nil
+}
+
+// 解析一天中的时间 HH:MM
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// 与 find -size 相同：+n 大于 n，-n 小于 n，n 等于 n
+func parseSizeFilter(value string, match *MatchConfig) error {
+	num := value
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		num = value[1:]
+	}
+	size, err := parseSize(num)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(value, "+"):
+		match.SetMinSize(size + 1)
+	case strings.HasPrefix(value, "-"):
+		// -0、-0.5 等不会匹配任何文件
+		if size <= 0 {
+			return fmt.Errorf("invalid size %q, no file is smaller than 0", value)
 		}
+		match.SetMaxSize(size - 1)
+	default:
+		match.SetMinSize(size)
+		match.SetMaxSize(size)
 	}
 	return nil
 }
@@ -88,6 +325,35 @@ func md5File(fpath string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
+// 读取路径列表文件，- 表示标准输入，nul 为 true 时以 \0 分隔，否则按行分隔，忽略空行
+func readPathList(file string, nul bool) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sep := "\n"
+	if nul {
+		sep = "\x00"
+	}
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		if !nul {
+			p = strings.TrimSpace(p)
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -128,6 +394,25 @@ func ResumePartSize(size int64) int64 {
 	return 100 * 1024 * 1024
 }
 
+// 数据流按预估大小 sizeHint 选择分片大小，预估可能偏小，留出 4 倍的余量，
+// 未知大小时使用 DefaultBlockSize，超过最大分片时返回错误
+func StreamPartSize(sizeHint int64) (int64, error) {
+	if sizeHint <= 0 {
+		return DefaultBlockSize, nil
+	}
+	partSizes := []int64{1024 * 1024, 10 * 1024 * 1024, 50 * 1024 * 1024, 100 * 1024 * 1024}
+	for _, partSize := range partSizes {
+		if sizeHint*4 <= partSize*partial.MaxStreamParts {
+			return partSize, nil
+		}
+	}
+	maxPart := partSizes[len(partSizes)-1]
+	if sizeHint > maxPart*partial.MaxStreamParts {
+		return 0, fmt.Errorf("size %d exceeds the stream upload limit of %d parts of %d bytes", sizeHint, partial.MaxStreamParts, maxPart)
+	}
+	return maxPart, nil
+}
+
 func cleanFilename(name string) string {
 	if !isWindowsGOOS() {
 		return name
@@ -5,4 +5,7 @@ import "errors"
 var (
 	ErrInvalidCommand = errors.New("invalid command")
 	ErrRequireLogin   = errors.New("log in to UpYun first")
+
+	// 本地文件与云端文件的 MD5 不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )